@@ -1,6 +1,11 @@
 package vaulter
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
 	vault "github.com/hashicorp/vault/api"
 )
 
@@ -19,8 +24,123 @@ type Vaulter interface {
 // VaultAPI provides an implementation of the Vaulter interface that can
 // actually hit the Vault API.
 type VaultAPI struct {
-	client *vault.Client
-	cfg    *vault.Config
+	client        *vault.Client
+	cfg           *vault.Config
+	mountDefaults *MountConfiguration
+	roleDefaults  *RoleDefaults
+	pathPrefix    string
+	displayPrefix string
+	namespace     string
+	middleware    []Middleware
+	operation     string
+
+	remountTimeout time.Duration
+}
+
+// unprefixedPaths lists the paths that aren't scoped by the path prefix, since
+// they can't be mounted anywhere else.
+var unprefixedPaths = []string{"sys/", "auth/", "cubbyhole/", "identity/"}
+
+// isUnprefixed returns true if the path should not be scoped by the path
+// prefix.
+func isUnprefixed(path string) bool {
+	path = strings.TrimPrefix(path, "/")
+	for _, u := range unprefixedPaths {
+		if path == strings.TrimSuffix(u, "/") || strings.HasPrefix(path, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixed returns the path scoped by the path prefix, if one is set. Reads of
+// a single mount's information at sys/mounts/<path> are scoped as well.
+func (v *VaultAPI) prefixed(path string) string {
+	if v.pathPrefix == "" {
+		return path
+	}
+	path = strings.TrimPrefix(path, "/")
+	if strings.HasPrefix(path, "sys/mounts/") {
+		return "sys/mounts/" + v.prefixed(strings.TrimPrefix(path, "sys/mounts/"))
+	}
+	if isUnprefixed(path) {
+		return path
+	}
+	return v.pathPrefix + "/" + path
+}
+
+// PathPrefixer is an interface for objects that scope the paths they send to
+// Vault with a prefix.
+type PathPrefixer interface {
+	PrefixedPath(path string) string
+}
+
+// PrefixedPath returns the path the way it's sent to Vault, scoped by the path
+// prefix if one is set.
+func (v *VaultAPI) PrefixedPath(path string) string {
+	return v.prefixed(path)
+}
+
+// prefixedPath returns the path the way it's sent to Vault if p is a
+// PathPrefixer, otherwise the path is returned as is.
+func prefixedPath(p interface{}, path string) string {
+	if pp, ok := p.(PathPrefixer); ok {
+		return pp.PrefixedPath(path)
+	}
+	return path
+}
+
+// PathPrefix returns the prefix that's prepended to mount and backend paths.
+func (v *VaultAPI) PathPrefix() string {
+	return v.pathPrefix
+}
+
+// SetPathPrefix sets a prefix that's prepended to the paths passed to mount,
+// read, write, list, and delete operations, so that several deployments can
+// share one Vault using separate path namespaces. For example, with a prefix
+// of "envA", the "pki" mount becomes "envA/pki". ListMounts only returns the
+// mounts under the prefix, with the prefix removed. The sys/, auth/,
+// cubbyhole/, and identity/ paths are never prefixed.
+func (v *VaultAPI) SetPathPrefix(prefix string) {
+	v.pathPrefix = strings.Trim(prefix, "/")
+}
+
+// OperationHeader is the request header WithOperation uses to tag requests
+// with the name of the high-level operation they're part of.
+const OperationHeader = "X-Vault-Operation"
+
+// WithOperation returns a copy of the VaultAPI whose client tags every request
+// with the operation name in the OperationHeader, so that audit log entries can
+// be tied back to the operation that caused them. Clients created through the
+// copy's NewClient() are tagged as well, so that functions such as WriteMount
+// and ReadMount that make requests with other tokens are tied to the operation
+// too. The original VaultAPI and its client are left untouched. Vault only
+// records the header in the audit log once it's been registered, see
+// RegisterOperationAuditHeader().
+func (v *VaultAPI) WithOperation(name string) (*VaultAPI, error) {
+	client, err := v.client.CloneWithHeaders()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(v.client.Token())
+	client.AddHeader(OperationHeader, name)
+	tagged := *v
+	tagged.client = client
+	tagged.operation = name
+	tagged.middleware = append([]Middleware(nil), v.middleware...)
+	return &tagged, nil
+}
+
+// RegisterOperationAuditHeader registers the OperationHeader with Vault at
+// sys/config/auditing/request-headers, which has to be done once before Vault
+// includes the header in its audit log entries. The operation names are logged
+// as is rather than HMACed so that they can be searched for. Requires sudo
+// capability on the path.
+func RegisterOperationAuditHeader(w LogicalWriter) error {
+	_, err := w.Write(w.Client(), "sys/config/auditing/request-headers/"+OperationHeader, map[string]interface{}{
+		"hmac": false,
+	})
+	return err
 }
 
 // Token returns a new Vault token.
@@ -28,40 +148,245 @@ func (v *VaultAPI) Token() *vault.TokenAuth {
 	return v.client.Auth().Token()
 }
 
-// CreateToken returns a new child or orphan token.
+// CreateToken returns a new child or orphan token. If a display name prefix is
+// set, it's prepended to the requested display name, or used as the display
+// name if none was requested. The options may be nil.
 func (v *VaultAPI) CreateToken(ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error) {
-	return ta.Create(opts)
+	return v.CreateTokenWithContext(context.Background(), ta, opts)
+}
+
+// CreateTokenWithContext creates a new child or orphan token the same way as
+// CreateToken(), giving up when the context is done.
+func (v *VaultAPI) CreateTokenWithContext(ctx context.Context, ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error) {
+	if v.displayPrefix != "" {
+		if opts == nil {
+			opts = &vault.TokenCreateRequest{}
+		}
+		prefixed := *opts
+		if prefixed.DisplayName == "" {
+			prefixed.DisplayName = v.displayPrefix
+		} else {
+			prefixed.DisplayName = v.displayPrefix + "-" + prefixed.DisplayName
+		}
+		opts = &prefixed
+	}
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "create-token", Path: "auth/token/create", Context: ctx}, func(op *Operation) (err error) {
+		secret, err = ta.CreateWithContext(op.Context, opts)
+		return err
+	})
+	return secret, err
+}
+
+// DisplayNamePrefix returns the prefix applied to the display names of tokens
+// created with CreateToken().
+func (v *VaultAPI) DisplayNamePrefix() string {
+	return v.displayPrefix
+}
+
+// SetDisplayNamePrefix sets the prefix applied to the display names of tokens
+// created with CreateToken(), so that they can be told apart in the audit log
+// and token lookups. For example, with a prefix of "jobworker", a token created
+// with a display name of "1234" shows up as "token-jobworker-1234".
+func (v *VaultAPI) SetDisplayNamePrefix(prefix string) {
+	v.displayPrefix = prefix
+}
+
+// LookupSelf looks up the token configured for the client.
+func (v *VaultAPI) LookupSelf() (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "lookup-self", Path: "auth/token/lookup-self"}, func(op *Operation) (err error) {
+		secret, err = v.client.Auth().Token().LookupSelf()
+		return err
+	})
+	return secret, err
+}
+
+// Lookup looks up the provided token. The token is sent in the request body,
+// so it's left out of the operation's path.
+func (v *VaultAPI) Lookup(token string) (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "lookup-token", Path: "auth/token/lookup"}, func(op *Operation) (err error) {
+		secret, err = v.client.Auth().Token().Lookup(token)
+		return err
+	})
+	return secret, err
+}
+
+// RevokeToken revokes the provided token along with its children. The token is
+// sent in the request body, so it's left out of the operation's path.
+func (v *VaultAPI) RevokeToken(token string) error {
+	return v.run(&Operation{Name: "revoke-token", Path: "auth/token/revoke"}, func(op *Operation) error {
+		return v.client.Auth().Token().RevokeTree(token)
+	})
 }
 
 // Mount uses the Vault API to mount a backend at a path.
 func (v *VaultAPI) Mount(path string, mi *vault.MountInput) error {
 	sys := v.client.Sys()
-	return sys.Mount(path, mi)
+	return v.run(&Operation{Name: "mount", Path: path}, func(op *Operation) error {
+		return sys.Mount(v.prefixed(op.Path), mi)
+	})
 }
 
 // Unmount uses the Vault API to unmount a backend at the provided path.
 func (v *VaultAPI) Unmount(path string) error {
-	return v.client.Sys().Unmount(path)
+	return v.run(&Operation{Name: "unmount", Path: path}, func(op *Operation) error {
+		return v.client.Sys().Unmount(v.prefixed(op.Path))
+	})
+}
+
+// DefaultRemountTimeout is how long Remount waits for a migration to finish
+// when no timeout has been set with SetRemountTimeout().
+const DefaultRemountTimeout = 5 * time.Minute
+
+// remountPollInterval is how long Remount waits between checks of a
+// migration's status.
+var remountPollInterval = time.Second
+
+// RemountTimeout returns how long Remount waits for a migration to finish.
+func (v *VaultAPI) RemountTimeout() time.Duration {
+	if v.remountTimeout <= 0 {
+		return DefaultRemountTimeout
+	}
+	return v.remountTimeout
+}
+
+// SetRemountTimeout sets how long Remount waits for a migration to finish
+// before giving up. A timeout of zero restores DefaultRemountTimeout.
+func (v *VaultAPI) SetRemountTimeout(timeout time.Duration) {
+	v.remountTimeout = timeout
+}
+
+// Remount uses the Vault API to move a backend from one path to another. The
+// status of the migration is polled until it succeeds or fails, or until the
+// remount timeout passes.
+func (v *VaultAPI) Remount(from, to string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.RemountTimeout())
+	defer cancel()
+	return v.RemountWithContext(ctx, from, to)
+}
+
+// RemountWithContext moves a backend from one path to another the same way as
+// Remount(), giving up when the context is done. Giving up doesn't stop the
+// migration in Vault, which may still finish later.
+func (v *VaultAPI) RemountWithContext(ctx context.Context, from, to string) error {
+	sys := v.client.Sys()
+	return v.run(&Operation{Name: "remount", Path: from, Context: ctx}, func(op *Operation) error {
+		src, dst := v.prefixed(op.Path), v.prefixed(to)
+		out, err := sys.StartRemountWithContext(op.Context, src, dst)
+		if err != nil {
+			return err
+		}
+		for {
+			status, err := sys.RemountStatusWithContext(op.Context, out.MigrationID)
+			if err != nil {
+				return err
+			}
+			if status.MigrationInfo != nil {
+				switch status.MigrationInfo.MigrationStatus {
+				case "success":
+					return nil
+				case "failure":
+					return fmt.Errorf("failed to move %s to %s, migration ID %s", src, dst, out.MigrationID)
+				}
+			}
+			if err = sleep(op.Context, remountPollInterval); err != nil {
+				return fmt.Errorf("timed out moving %s to %s, migration ID %s: %w", src, dst, out.MigrationID, err)
+			}
+		}
+	})
 }
 
 // MountConfig uses the VaultAPI to get the config for the passed in mount
 // point.
 func (v *VaultAPI) MountConfig(path string) (*vault.MountConfigOutput, error) {
+	var out *vault.MountConfigOutput
 	sys := v.client.Sys()
-	return sys.MountConfig(path)
+	err := v.run(&Operation{Name: "mount-config", Path: path}, func(op *Operation) (err error) {
+		out, err = sys.MountConfig(v.prefixed(op.Path))
+		return err
+	})
+	return out, err
 }
 
 // TuneMount uses the VaultAPI to set the config for the passed in mount
 // point.
 func (v *VaultAPI) TuneMount(path string, in vault.MountConfigInput) error {
 	sys := v.client.Sys()
-	return sys.TuneMount(path, in)
+	return v.run(&Operation{Name: "tune-mount", Path: path}, func(op *Operation) error {
+		return sys.TuneMount(v.prefixed(op.Path), in)
+	})
 }
 
-// ListMounts lists the mounted Vault backends.
+// ListMounts lists the mounted Vault backends. If a path prefix is set, only
+// the backends mounted under the prefix are listed, with the prefix removed.
 func (v *VaultAPI) ListMounts() (map[string]*vault.MountOutput, error) {
+	var mounts map[string]*vault.MountOutput
 	sys := v.client.Sys()
-	return sys.ListMounts()
+	err := v.run(&Operation{Name: "list-mounts", Path: "sys/mounts"}, func(op *Operation) (err error) {
+		mounts, err = sys.ListMounts()
+		return err
+	})
+	if err != nil || v.pathPrefix == "" {
+		return mounts, err
+	}
+	scoped := make(map[string]*vault.MountOutput)
+	for path, mo := range mounts {
+		if isUnprefixed(path) {
+			scoped[path] = mo
+		} else if strings.HasPrefix(path, v.pathPrefix+"/") {
+			scoped[strings.TrimPrefix(path, v.pathPrefix+"/")] = mo
+		}
+	}
+	return scoped, nil
+}
+
+// EnableAuthWithOptions enables an auth method at the provided path.
+func (v *VaultAPI) EnableAuthWithOptions(path string, options *vault.EnableAuthOptions) error {
+	return v.run(&Operation{Name: "enable-auth", Path: path}, func(op *Operation) error {
+		return v.client.Sys().EnableAuthWithOptions(op.Path, options)
+	})
+}
+
+// ListAuth lists the enabled auth methods.
+func (v *VaultAPI) ListAuth() (map[string]*vault.AuthMount, error) {
+	var auths map[string]*vault.AuthMount
+	err := v.run(&Operation{Name: "list-auth", Path: "sys/auth"}, func(op *Operation) (err error) {
+		auths, err = v.client.Sys().ListAuth()
+		return err
+	})
+	return auths, err
+}
+
+// GetPolicy returns the rules for the named ACL policy.
+func (v *VaultAPI) GetPolicy(name string) (string, error) {
+	var rules string
+	err := v.run(&Operation{Name: "get-policy", Path: "sys/policy/" + name}, func(op *Operation) (err error) {
+		rules, err = v.client.Sys().GetPolicy(name)
+		return err
+	})
+	return rules, err
+}
+
+// Health returns the health status of the Vault server.
+func (v *VaultAPI) Health() (*vault.HealthResponse, error) {
+	var health *vault.HealthResponse
+	err := v.run(&Operation{Name: "health", Path: "sys/health"}, func(op *Operation) (err error) {
+		health, err = v.client.Sys().Health()
+		return err
+	})
+	return health, err
+}
+
+// SealStatus returns the seal status of the Vault server.
+func (v *VaultAPI) SealStatus() (*vault.SealStatusResponse, error) {
+	var status *vault.SealStatusResponse
+	err := v.run(&Operation{Name: "seal-status", Path: "sys/seal-status"}, func(op *Operation) (err error) {
+		status, err = v.client.Sys().SealStatus()
+		return err
+	})
+	return status, err
 }
 
 // DefaultConfig returns a *vault.Config filled out with the default values.
@@ -76,9 +401,35 @@ func (v *VaultAPI) ConfigureTLS(cfg *vault.Config, t *vault.TLSConfig) error {
 	return cfg.ConfigureTLS(t)
 }
 
-// NewClient creates a new Vault client.
+// NewClient creates a new Vault client. If a namespace is set, the new client
+// uses it too. If the VaultAPI was returned by WithOperation(), the new client
+// tags its requests with the operation name.
 func (v *VaultAPI) NewClient(cfg *vault.Config) (*vault.Client, error) {
-	return vault.NewClient(cfg)
+	client, err := vault.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if v.namespace != "" {
+		client.SetNamespace(v.namespace)
+	}
+	if v.operation != "" {
+		client.AddHeader(OperationHeader, v.operation)
+	}
+	return client, nil
+}
+
+// Namespace returns the Vault Enterprise namespace that requests are made in.
+func (v *VaultAPI) Namespace() string {
+	return v.namespace
+}
+
+// SetNamespace sets the Vault Enterprise namespace that requests are made in,
+// both for the current client and for clients created with NewClient().
+func (v *VaultAPI) SetNamespace(ns string) {
+	v.namespace = ns
+	if v.client != nil {
+		v.client.SetNamespace(ns)
+	}
 }
 
 // SetClient sets the value of the internal *vault.Client field.
@@ -102,30 +453,149 @@ func (v *VaultAPI) SetConfig(cfg *vault.Config) {
 	v.cfg = cfg
 }
 
+// DefaultMountConfig returns the MountConfiguration whose values are used by
+// Mount() for any fields left empty by the caller. May be nil.
+func (v *VaultAPI) DefaultMountConfig() *MountConfiguration {
+	return v.mountDefaults
+}
+
+// SetDefaultMountConfig sets the MountConfiguration whose values are used by
+// Mount() for any fields left empty by the caller.
+func (v *VaultAPI) SetDefaultMountConfig(c *MountConfiguration) {
+	v.mountDefaults = c
+}
+
+// RoleDefaults returns the role settings used by CreateRole() and IssueCert()
+// when callers don't provide their own. May be nil.
+func (v *VaultAPI) RoleDefaults() *RoleDefaults {
+	return v.roleDefaults
+}
+
+// SetRoleDefaults sets the role settings used by CreateRole() and IssueCert()
+// when callers don't provide their own.
+func (v *VaultAPI) SetRoleDefaults(d *RoleDefaults) {
+	v.roleDefaults = d
+}
+
 // SetToken sets the root token for the provided vault client.
 func (v *VaultAPI) SetToken(client *vault.Client, t string) {
 	client.SetToken(t)
 }
 
 func (v *VaultAPI) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	return v.WriteWithContext(context.Background(), client, path, data)
+}
+
+// WriteWithContext writes data to a path in a backend, giving up when the
+// context is done.
+func (v *VaultAPI) WriteWithContext(ctx context.Context, client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	var secret *vault.Secret
 	logical := client.Logical()
-	secret, err := logical.Write(path, data)
+	err := v.run(&Operation{Name: "write", Path: path, Context: ctx}, func(op *Operation) (err error) {
+		secret, err = logical.WriteWithContext(op.Context, v.prefixed(op.Path), data)
+		return err
+	})
 	return secret, err
 }
 
 func (v *VaultAPI) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	return v.ReadWithContext(context.Background(), client, path)
+}
+
+// ReadWithData reads data from a path in a backend, passing the data as query
+// parameters.
+func (v *VaultAPI) ReadWithData(client *vault.Client, path string, data map[string][]string) (*vault.Secret, error) {
+	var secret *vault.Secret
+	logical := client.Logical()
+	err := v.run(&Operation{Name: "read", Path: path}, func(op *Operation) (err error) {
+		secret, err = logical.ReadWithData(v.prefixed(op.Path), data)
+		return err
+	})
+	return secret, err
+}
+
+// ReadWithContext reads data from a path in a backend, giving up when the
+// context is done.
+func (v *VaultAPI) ReadWithContext(ctx context.Context, client *vault.Client, path string) (*vault.Secret, error) {
+	var secret *vault.Secret
 	logical := client.Logical()
-	return logical.Read(path)
+	err := v.run(&Operation{Name: "read", Path: path, Context: ctx}, func(op *Operation) (err error) {
+		secret, err = logical.ReadWithContext(op.Context, v.prefixed(op.Path))
+		return err
+	})
+	return secret, err
+}
+
+// List returns the keys stored under a path in a backend.
+func (v *VaultAPI) List(client *vault.Client, path string) (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "list", Path: path}, func(op *Operation) (err error) {
+		secret, err = client.Logical().List(v.prefixed(op.Path))
+		return err
+	})
+	return secret, err
 }
 
 // Delete removes a path from a backend.
 func (v *VaultAPI) Delete(client *vault.Client, path string) (*vault.Secret, error) {
-	return client.Logical().Delete(path)
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "delete", Path: path}, func(op *Operation) (err error) {
+		secret, err = client.Logical().Delete(v.prefixed(op.Path))
+		return err
+	})
+	return secret, err
 }
 
 // Revoke revokes the object represented by the passed-in id.
 func (v *VaultAPI) Revoke(client *vault.Client, id string) error {
-	return client.Sys().Revoke(id)
+	return v.run(&Operation{Name: "revoke", Path: id}, func(op *Operation) error {
+		return client.Sys().Revoke(op.Path)
+	})
+}
+
+// Renew extends the lease with the passed-in id by the increment in seconds.
+func (v *VaultAPI) Renew(client *vault.Client, leaseID string, increment int) (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "renew", Path: leaseID}, func(op *Operation) (err error) {
+		secret, err = client.Sys().Renew(op.Path, increment)
+		return err
+	})
+	return secret, err
+}
+
+// LoginAppRole logs in to Vault with an AppRole role ID and secret ID.
+func (v *VaultAPI) LoginAppRole(roleID, secretID string) (*vault.Secret, error) {
+	return v.login("auth/approle/login", map[string]interface{}{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+}
+
+// LoginUserpass logs in to Vault with a username and password.
+func (v *VaultAPI) LoginUserpass(username, password string) (*vault.Secret, error) {
+	return v.login("auth/userpass/login/"+username, map[string]interface{}{
+		"password": password,
+	})
+}
+
+// LoginKubernetes logs in to Vault as the Kubernetes auth role with a service
+// account JWT.
+func (v *VaultAPI) LoginKubernetes(role, jwt string) (*vault.Secret, error) {
+	return v.login("auth/kubernetes/login", map[string]interface{}{
+		"role": role,
+		"jwt":  jwt,
+	})
+}
+
+// login writes the credentials to an auth method's login path as a "login"
+// operation. Auth paths aren't affected by the path prefix.
+func (v *VaultAPI) login(path string, data map[string]interface{}) (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.run(&Operation{Name: "login", Path: path}, func(op *Operation) (err error) {
+		secret, err = v.client.Logical().Write(op.Path, data)
+		return err
+	})
+	return secret, err
 }
 
 // VaultAPIConfig contains the applications configuration settings.
@@ -137,4 +607,33 @@ type VaultAPIConfig struct {
 	CACert      string // The path to the PEM-encoded CA cert file used to verify the Vault server SSL cert.
 	ClientCert  string // The path to the client cert used for Vault communication.
 	ClientKey   string // The paht to the client key used for Vault communication.
+	Insecure    bool   // Skips verifying the Vault server's certificate. Only use this for development.
+
+	// Retry settings for the Vault client. Zero values leave the Vault client's
+	// defaults in place.
+	MaxRetries   int           // The maximum number of times a failed request is retried.
+	MinRetryWait time.Duration // The minimum time to wait before retrying a request.
+	MaxRetryWait time.Duration // The maximum time to wait before retrying a request.
+
+	RoleDefaults      *RoleDefaults // Default role settings. May be nil.
+	DisplayNamePrefix string        // Prepended to the display names of created tokens.
+	Namespace         string        // The Vault Enterprise namespace to use. Optional.
+	RemountTimeout    time.Duration // How long Remount waits for a migration. Zero uses DefaultRemountTimeout.
+
+	// AppRole credentials. If InitAPI is called without a token and both of
+	// these are set, the token is obtained by logging in with them.
+	RoleID   string
+	SecretID string
+
+	// Kubernetes auth settings. If InitAPI is called without a token and
+	// KubernetesRole is set, the token is obtained by logging in with the
+	// service account JWT read from KubernetesJWTPath, which defaults to
+	// DefaultKubernetesJWTPath.
+	KubernetesRole    string
+	KubernetesJWTPath string
+
+	// Root token checks. Provisioning should be done with a scoped token rather
+	// than the root token.
+	CheckRootToken  bool // Log a warning if the token is the root token.
+	RejectRootToken bool // Return an error from InitAPI if the token is the root token.
 }
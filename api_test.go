@@ -0,0 +1,523 @@
+package vaulter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// requestRecorder records the method and path of each request made to a test
+// Vault server.
+type requestRecorder struct {
+	mu       sync.Mutex
+	requests []string
+	body     string
+}
+
+func (r *requestRecorder) handler(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+	r.body = string(body)
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/v1/sys/mounts":
+		fmt.Fprint(w, `{"data":{
+			"envA/pki/": {"type":"pki"},
+			"envB/pki/": {"type":"pki"},
+			"secret/": {"type":"kv"},
+			"cubbyhole/": {"type":"cubbyhole"}
+		}}`)
+	case req.Method == http.MethodGet:
+		fmt.Fprint(w, `{"data":{"keys":["foo"]}}`)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (r *requestRecorder) last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) == 0 {
+		return ""
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+func TestPathPrefix(t *testing.T) {
+	rec := &requestRecorder{}
+	client := newTestClient(t, rec.handler)
+	api := &VaultAPI{}
+	api.SetClient(client)
+	api.SetPathPrefix("/envA/")
+	if api.PathPrefix() != "envA" {
+		t.Errorf("path prefix was '%s' instead of 'envA'", api.PathPrefix())
+	}
+
+	checks := []struct {
+		name     string
+		op       func() error
+		expected string
+	}{
+		{"write", func() error {
+			_, err := api.Write(client, "pki/issue/foo", map[string]interface{}{})
+			return err
+		}, "PUT /v1/envA/pki/issue/foo"},
+		{"read", func() error {
+			_, err := api.Read(client, "pki/roles/foo")
+			return err
+		}, "GET /v1/envA/pki/roles/foo"},
+		{"list", func() error {
+			_, err := api.List(client, "pki/roles")
+			return err
+		}, "GET /v1/envA/pki/roles"},
+		{"delete", func() error {
+			_, err := api.Delete(client, "pki/roles/foo")
+			return err
+		}, "DELETE /v1/envA/pki/roles/foo"},
+		{"mount", func() error {
+			return Mount(api, "pki", &MountConfiguration{Type: "pki"})
+		}, "POST /v1/sys/mounts/envA/pki"},
+		{"unmount", func() error {
+			return Unmount(api, "pki")
+		}, "DELETE /v1/sys/mounts/envA/pki"},
+		{"tune", func() error {
+			return api.TuneMount("pki", vault.MountConfigInput{MaxLeaseTTL: "24h"})
+		}, "POST /v1/sys/mounts/envA/pki/tune"},
+		{"mount info", func() error {
+			_, err := api.Read(client, "sys/mounts/pki")
+			return err
+		}, "GET /v1/sys/mounts/envA/pki"},
+		{"cubbyhole", func() error {
+			_, err := api.Read(client, "cubbyhole/token")
+			return err
+		}, "GET /v1/cubbyhole/token"},
+		{"auth", func() error {
+			_, err := api.Write(client, "auth/token/create", map[string]interface{}{})
+			return err
+		}, "PUT /v1/auth/token/create"},
+		{"ca access", func() error {
+			_, err := ConfigCAAccess(api, "https", "vault:8200", "pki")
+			return err
+		}, "PUT /v1/envA/pki/config/urls"},
+		{"raw read", func() error {
+			_, _, err := ReadRawSecret(api, "pki/cert/ca")
+			return err
+		}, "GET /v1/envA/pki/cert/ca"},
+	}
+	for _, c := range checks {
+		if err := c.op(); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if actual := rec.last(); actual != c.expected {
+			t.Errorf("%s: request was '%s' instead of '%s'", c.name, actual, c.expected)
+		}
+	}
+
+	if _, err := ConfigCAAccess(api, "https", "vault:8200", "pki"); err != nil {
+		t.Fatal(err)
+	}
+	var urls map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.body), &urls); err != nil {
+		t.Fatal(err)
+	}
+	if urls["issuing_certificates"] != "https://vault:8200/v1/envA/pki/ca" {
+		t.Errorf("issuing_certificates was %v instead of the prefixed URL", urls["issuing_certificates"])
+	}
+	if urls["crl_distribution_points"] != "https://vault:8200/v1/envA/pki/crl" {
+		t.Errorf("crl_distribution_points was %v instead of the prefixed URL", urls["crl_distribution_points"])
+	}
+
+	mounts, err := api.ListMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 2 {
+		t.Errorf("mounts were %v instead of pki/ and cubbyhole/", mounts)
+	}
+	for _, path := range []string{"pki", "cubbyhole"} {
+		m, err := IsMounted(api, path)
+		if err != nil {
+			t.Error(err)
+		}
+		if !m {
+			t.Errorf("%s was not found under the prefix", path)
+		}
+	}
+	m, err := IsMounted(api, "secret")
+	if err != nil {
+		t.Error(err)
+	}
+	if m {
+		t.Error("secret was found under the prefix")
+	}
+
+	api.SetPathPrefix("")
+	if _, err = api.Read(client, "pki/roles/foo"); err != nil {
+		t.Error(err)
+	}
+	if actual := rec.last(); actual != "GET /v1/pki/roles/foo" {
+		t.Errorf("request was '%s' instead of 'GET /v1/pki/roles/foo'", actual)
+	}
+	mounts, err = api.ListMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 4 {
+		t.Errorf("mounts were %v instead of all four mounts", mounts)
+	}
+}
+
+func TestWithOperation(t *testing.T) {
+	var mu sync.Mutex
+	var ops, tokens []string
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ops = append(ops, req.Header.Get(OperationHeader))
+		tokens = append(tokens, req.Header.Get("X-Vault-Token"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{}}`)
+	})
+	api := &VaultAPI{}
+	api.SetClient(client)
+	api.SetPathPrefix("envA")
+
+	issue, err := api.WithOperation("issue-htcondor-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue.PathPrefix() != "envA" {
+		t.Errorf("path prefix was '%s' instead of 'envA'", issue.PathPrefix())
+	}
+	if _, err = IssueCert(issue, "pki", "htcondor", &IssueCertConfig{CommonName: "foo.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.Read(api.Client(), "pki/roles/htcondor"); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := api.WithOperation("check-roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = roles.Read(roles.Client(), "pki/roles/htcondor"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"issue-htcondor-cert", "", "check-roles"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ops) != len(expected) {
+		t.Fatalf("there were %d requests instead of %d", len(ops), len(expected))
+	}
+	for i := range expected {
+		if ops[i] != expected[i] {
+			t.Errorf("request %d had operation '%s' instead of '%s'", i, ops[i], expected[i])
+		}
+		if tokens[i] != "test-token" {
+			t.Errorf("request %d had token '%s' instead of 'test-token'", i, tokens[i])
+		}
+	}
+}
+
+func TestWithOperationNewClient(t *testing.T) {
+	var mu sync.Mutex
+	var ops, tokens []string
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ops = append(ops, req.Header.Get(OperationHeader))
+		tokens = append(tokens, req.Header.Get("X-Vault-Token"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"irods-config":"foo"}}`)
+	})
+	cfg := vault.DefaultConfig()
+	cfg.Address = client.Address()
+	cfg.MaxRetries = 0
+	api := &VaultAPI{}
+	api.SetClient(client)
+	api.SetConfig(cfg)
+
+	tagged, err := api.WithOperation("write-job-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"irods-config": "foo"}
+	if err = WriteMount(tagged, "cubbyhole/config", "job-token", data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadMount(tagged, "cubbyhole/config", "job-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteMount(api, "cubbyhole/config", "job-token", data); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"write-job-config", "write-job-config", ""}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ops) != len(expected) {
+		t.Fatalf("there were %d requests instead of %d", len(ops), len(expected))
+	}
+	for i := range expected {
+		if ops[i] != expected[i] {
+			t.Errorf("request %d had operation '%s' instead of '%s'", i, ops[i], expected[i])
+		}
+		if tokens[i] != "job-token" {
+			t.Errorf("request %d had token '%s' instead of 'job-token'", i, tokens[i])
+		}
+	}
+}
+
+func TestRegisterOperationAuditHeader(t *testing.T) {
+	rec := &requestRecorder{}
+	api := &VaultAPI{}
+	api.SetClient(newTestClient(t, rec.handler))
+	api.SetPathPrefix("envA")
+	if err := RegisterOperationAuditHeader(api); err != nil {
+		t.Fatal(err)
+	}
+	if rec.last() != "PUT /v1/sys/config/auditing/request-headers/X-Vault-Operation" {
+		t.Errorf("request was '%s'", rec.last())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["hmac"] != false {
+		t.Errorf("hmac was %v instead of false", body["hmac"])
+	}
+}
+
+func TestUnmountMissing(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":["no matching mount at \"missing/\""]}`)
+	})
+	api := &VaultAPI{}
+	api.SetClient(client)
+	err := Unmount(api, "missing")
+	if err == nil {
+		t.Fatal("err was nil for a missing mount")
+	}
+	respErr, ok := err.(*vault.ResponseError)
+	if !ok {
+		t.Fatalf("err was a %T instead of a *vault.ResponseError", err)
+	}
+	if respErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code was %d instead of %d", respErr.StatusCode, http.StatusBadRequest)
+	}
+	if len(respErr.Errors) != 1 || respErr.Errors[0] != `no matching mount at "missing/"` {
+		t.Errorf("errors were %v", respErr.Errors)
+	}
+}
+
+func TestCreateTokenDisplayNamePrefix(t *testing.T) {
+	var displayNames []string
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		name, _ := body["display_name"].(string)
+		displayNames = append(displayNames, name)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"auth":{"client_token":"child-token"}}`)
+	})
+	api := &VaultAPI{}
+	api.SetClient(client)
+
+	opts := &vault.TokenCreateRequest{DisplayName: "1234"}
+	if _, err := api.CreateToken(api.Token(), opts); err != nil {
+		t.Fatal(err)
+	}
+	api.SetDisplayNamePrefix("jobworker")
+	if api.DisplayNamePrefix() != "jobworker" {
+		t.Errorf("display name prefix was '%s' instead of 'jobworker'", api.DisplayNamePrefix())
+	}
+	if _, err := api.CreateToken(api.Token(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.CreateToken(api.Token(), &vault.TokenCreateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.CreateToken(api.Token(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"1234", "jobworker-1234", "jobworker", "jobworker"}
+	if len(displayNames) != len(expected) {
+		t.Fatalf("there were %d requests instead of %d", len(displayNames), len(expected))
+	}
+	for i := range expected {
+		if displayNames[i] != expected[i] {
+			t.Errorf("request %d had display name '%s' instead of '%s'", i, displayNames[i], expected[i])
+		}
+	}
+	if opts.DisplayName != "1234" {
+		t.Errorf("the caller's display name was changed to '%s'", opts.DisplayName)
+	}
+}
+
+// remountServer returns a handler that starts a migration and reports the
+// statuses in order as it's polled, repeating the last one.
+func remountServer(t *testing.T, statuses ...string) (http.HandlerFunc, *requestRecorder) {
+	rec := &requestRecorder{}
+	polls := 0
+	return func(w http.ResponseWriter, req *http.Request) {
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+		rec.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/v1/sys/remount":
+			body, _ := io.ReadAll(req.Body)
+			rec.mu.Lock()
+			rec.body = string(body)
+			rec.mu.Unlock()
+			fmt.Fprint(w, `{"data":{"migration_id":"abc"}}`)
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/sys/remount/status/abc":
+			status := statuses[len(statuses)-1]
+			if polls < len(statuses) {
+				status = statuses[polls]
+			}
+			polls++
+			fmt.Fprintf(w, `{"data":{"migration_id":"abc","migration_info":{"source_mount":"envA/pki/","target_mount":"envA/pki-old/","status":"%s"}}}`, status)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, rec
+}
+
+func TestVaultAPIRemount(t *testing.T) {
+	orig := remountPollInterval
+	remountPollInterval = time.Millisecond
+	t.Cleanup(func() { remountPollInterval = orig })
+
+	handler, rec := remountServer(t, "in-progress", "in-progress", "success")
+	api := &VaultAPI{}
+	api.SetClient(newTestClient(t, handler))
+	api.SetPathPrefix("envA")
+	if api.RemountTimeout() != DefaultRemountTimeout {
+		t.Errorf("remount timeout was %s instead of %s", api.RemountTimeout(), DefaultRemountTimeout)
+	}
+	if err := Remount(api, "pki", "pki-old"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"POST /v1/sys/remount",
+		"GET /v1/sys/remount/status/abc",
+		"GET /v1/sys/remount/status/abc",
+		"GET /v1/sys/remount/status/abc",
+	}
+	if len(rec.requests) != len(expected) {
+		t.Fatalf("requests were %v instead of %v", rec.requests, expected)
+	}
+	for i := range expected {
+		if rec.requests[i] != expected[i] {
+			t.Errorf("request %d was '%s' instead of '%s'", i, rec.requests[i], expected[i])
+		}
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["from"] != "envA/pki" || body["to"] != "envA/pki-old" {
+		t.Errorf("remount body was %s", rec.body)
+	}
+}
+
+func TestVaultAPIRemountFailure(t *testing.T) {
+	orig := remountPollInterval
+	remountPollInterval = time.Millisecond
+	t.Cleanup(func() { remountPollInterval = orig })
+
+	handler, rec := remountServer(t, "in-progress", "failure")
+	api := &VaultAPI{}
+	api.SetClient(newTestClient(t, handler))
+	if err := Remount(api, "pki", "pki-old"); err == nil {
+		t.Error("err was nil for a failed migration")
+	}
+	if len(rec.requests) != 3 {
+		t.Errorf("requests were %v", rec.requests)
+	}
+}
+
+func TestVaultAPIRemountTimeout(t *testing.T) {
+	orig := remountPollInterval
+	remountPollInterval = time.Millisecond
+	t.Cleanup(func() { remountPollInterval = orig })
+
+	handler, _ := remountServer(t, "in-progress")
+	api := &VaultAPI{}
+	api.SetClient(newTestClient(t, handler))
+	api.SetRemountTimeout(50 * time.Millisecond)
+	if api.RemountTimeout() != 50*time.Millisecond {
+		t.Errorf("remount timeout was %s instead of 50ms", api.RemountTimeout())
+	}
+	start := time.Now()
+	err := Remount(api, "pki", "pki-old")
+	if err == nil {
+		t.Fatal("err was nil for a migration that never finished")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err was '%s' instead of a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("remount gave up after %s", elapsed)
+	}
+}
+
+func TestReadWithContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	})
+	api := &VaultAPI{}
+	api.SetClient(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := api.ReadWithContext(ctx, client, "secret/foo"); err == nil {
+		t.Error("err was nil for a cancelled read")
+	}
+	if _, err := api.WriteWithContext(ctx, client, "secret/foo", map[string]interface{}{}); err == nil {
+		t.Error("err was nil for a cancelled write")
+	}
+	if _, err := api.CreateTokenWithContext(ctx, api.Token(), &vault.TokenCreateRequest{}); err == nil {
+		t.Error("err was nil for a cancelled token create")
+	}
+}
+
+func TestHealthTransportError(t *testing.T) {
+	cfg := vault.DefaultConfig()
+	cfg.Address = "http://127.0.0.1:1"
+	cfg.MaxRetries = 0
+	client, err := vault.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &VaultAPI{}
+	api.SetClient(client)
+	if _, err = Health(api); err == nil {
+		t.Error("err was nil for an unreachable server")
+	}
+	if _, err = IsInitialized(api); err == nil {
+		t.Error("err was nil for an unreachable server")
+	}
+}
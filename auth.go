@@ -0,0 +1,121 @@
+package vaulter
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// AppRoleAuthenticator is an interface for objects that can log in to Vault
+// with an AppRole role ID and secret ID.
+type AppRoleAuthenticator interface {
+	LoginAppRole(roleID, secretID string) (*vault.Secret, error)
+}
+
+// UserpassAuthenticator is an interface for objects that can log in to Vault
+// with a username and password.
+type UserpassAuthenticator interface {
+	LoginUserpass(username, password string) (*vault.Secret, error)
+}
+
+// K8sAuthenticator is an interface for objects that can log in to Vault with a
+// Kubernetes service account JWT.
+type K8sAuthenticator interface {
+	LoginKubernetes(role, jwt string) (*vault.Secret, error)
+}
+
+// AuthEnabler is an interface for objects that can enable auth methods in
+// Vault.
+type AuthEnabler interface {
+	EnableAuthWithOptions(path string, options *vault.EnableAuthOptions) error
+}
+
+// AuthLister is an interface for objects that can list the enabled auth
+// methods in Vault.
+type AuthLister interface {
+	ListAuth() (map[string]*vault.AuthMount, error)
+}
+
+// DefaultKubernetesJWTPath is where Kubernetes mounts the service account token
+// inside of a pod.
+const DefaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// clientToken returns the client token from the response to a login request.
+func clientToken(secret *vault.Secret, method string) (string, error) {
+	if secret == nil || secret.Auth == nil {
+		return "", errors.New("no auth information was returned for the " + method + " login")
+	}
+	if secret.Auth.ClientToken == "" {
+		return "", errors.New("no client token was returned for the " + method + " login")
+	}
+	return secret.Auth.ClientToken, nil
+}
+
+// LoginAppRole logs in to Vault with an AppRole role ID and secret ID and
+// returns the resulting client token.
+func LoginAppRole(a AppRoleAuthenticator, roleID, secretID string) (string, error) {
+	secret, err := a.LoginAppRole(roleID, secretID)
+	if err != nil {
+		return "", err
+	}
+	return clientToken(secret, "approle")
+}
+
+// LoginUserpass logs in to Vault with a username and password and returns the
+// resulting client token.
+func LoginUserpass(a UserpassAuthenticator, username, password string) (string, error) {
+	secret, err := a.LoginUserpass(username, password)
+	if err != nil {
+		return "", err
+	}
+	return clientToken(secret, "userpass")
+}
+
+// LoginKubernetes logs in to Vault as the Kubernetes auth role with a service
+// account JWT and returns the resulting client token.
+func LoginKubernetes(a K8sAuthenticator, role, jwt string) (string, error) {
+	secret, err := a.LoginKubernetes(role, jwt)
+	if err != nil {
+		return "", err
+	}
+	return clientToken(secret, "kubernetes")
+}
+
+// readKubernetesJWT reads a service account JWT from the file at path, or from
+// DefaultKubernetesJWTPath if path is empty.
+func readKubernetesJWT(path string) (string, error) {
+	if path == "" {
+		path = DefaultKubernetesJWTPath
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	jwt := strings.TrimSpace(string(b))
+	if jwt == "" {
+		return "", errors.New("the service account token in " + path + " is empty")
+	}
+	return jwt, nil
+}
+
+// EnableAuth enables an auth method, such as approle or kubernetes, at the
+// provided path.
+func EnableAuth(e AuthEnabler, path string, options *vault.EnableAuthOptions) error {
+	return e.EnableAuthWithOptions(path, options)
+}
+
+// IsAuthEnabled returns true if an auth method is enabled at the given path.
+func IsAuthEnabled(l AuthLister, path string) (bool, error) {
+	auths, err := l.ListAuth()
+	if err != nil {
+		return false, err
+	}
+	for a := range auths {
+		if strings.TrimSuffix(a, "/") == strings.TrimSuffix(path, "/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
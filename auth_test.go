@@ -0,0 +1,260 @@
+package vaulter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubAppRoleAuthenticator struct {
+	roleID     string
+	secretID   string
+	secret     *vault.Secret
+	loginError bool
+}
+
+func (s *StubAppRoleAuthenticator) LoginAppRole(roleID, secretID string) (*vault.Secret, error) {
+	s.roleID = roleID
+	s.secretID = secretID
+	if s.loginError {
+		return nil, errors.New("login error")
+	}
+	return s.secret, nil
+}
+
+type StubUserpassAuthenticator struct {
+	username   string
+	password   string
+	secret     *vault.Secret
+	loginError bool
+}
+
+func (s *StubUserpassAuthenticator) LoginUserpass(username, password string) (*vault.Secret, error) {
+	s.username = username
+	s.password = password
+	if s.loginError {
+		return nil, errors.New("login error")
+	}
+	return s.secret, nil
+}
+
+type StubK8sAuthenticator struct {
+	role       string
+	jwt        string
+	secret     *vault.Secret
+	loginError bool
+}
+
+func (s *StubK8sAuthenticator) LoginKubernetes(role, jwt string) (*vault.Secret, error) {
+	s.role = role
+	s.jwt = jwt
+	if s.loginError {
+		return nil, errors.New("login error")
+	}
+	return s.secret, nil
+}
+
+type StubAuthEnabler struct {
+	path        string
+	options     *vault.EnableAuthOptions
+	enableError bool
+}
+
+func (s *StubAuthEnabler) EnableAuthWithOptions(path string, options *vault.EnableAuthOptions) error {
+	s.path = path
+	s.options = options
+	if s.enableError {
+		return errors.New("enable error")
+	}
+	return nil
+}
+
+type StubAuthLister struct {
+	returnErr bool
+}
+
+func (s *StubAuthLister) ListAuth() (map[string]*vault.AuthMount, error) {
+	if s.returnErr {
+		return nil, errors.New("list auth error")
+	}
+	return map[string]*vault.AuthMount{
+		"token/":      {Type: "token"},
+		"approle/":    {Type: "approle"},
+		"kubernetes/": {Type: "kubernetes"},
+	}, nil
+}
+
+func TestLoginAppRole(t *testing.T) {
+	a := &StubAppRoleAuthenticator{
+		secret: &vault.Secret{
+			Auth: &vault.SecretAuth{ClientToken: "approle-token"},
+		},
+	}
+	token, err := LoginAppRole(a, "role-id", "secret-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "approle-token" {
+		t.Errorf("token was '%s' instead of 'approle-token'", token)
+	}
+	if a.roleID != "role-id" {
+		t.Errorf("role ID was '%s' instead of 'role-id'", a.roleID)
+	}
+	if a.secretID != "secret-id" {
+		t.Errorf("secret ID was '%s' instead of 'secret-id'", a.secretID)
+	}
+
+	a = &StubAppRoleAuthenticator{loginError: true}
+	if _, err = LoginAppRole(a, "role-id", "secret-id"); err == nil {
+		t.Error("err was nil when the login failed")
+	}
+
+	a = &StubAppRoleAuthenticator{secret: &vault.Secret{}}
+	if _, err = LoginAppRole(a, "role-id", "secret-id"); err == nil {
+		t.Error("err was nil when no auth information was returned")
+	}
+}
+
+func TestLoginUserpass(t *testing.T) {
+	a := &StubUserpassAuthenticator{
+		secret: &vault.Secret{
+			Auth: &vault.SecretAuth{ClientToken: "userpass-token"},
+		},
+	}
+	token, err := LoginUserpass(a, "operator", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "userpass-token" {
+		t.Errorf("token was '%s' instead of 'userpass-token'", token)
+	}
+	if a.username != "operator" {
+		t.Errorf("username was '%s' instead of 'operator'", a.username)
+	}
+	if a.password != "hunter2" {
+		t.Errorf("password was '%s' instead of 'hunter2'", a.password)
+	}
+
+	a = &StubUserpassAuthenticator{
+		secret: &vault.Secret{Auth: &vault.SecretAuth{}},
+	}
+	if _, err = LoginUserpass(a, "operator", "hunter2"); err == nil {
+		t.Error("err was nil when the client token was empty")
+	}
+
+	a = &StubUserpassAuthenticator{secret: &vault.Secret{}}
+	if _, err = LoginUserpass(a, "operator", "hunter2"); err == nil {
+		t.Error("err was nil when no auth information was returned")
+	}
+
+	a = &StubUserpassAuthenticator{loginError: true}
+	if _, err = LoginUserpass(a, "operator", "hunter2"); err == nil {
+		t.Error("err was nil when the login failed")
+	}
+}
+
+func TestLoginKubernetes(t *testing.T) {
+	a := &StubK8sAuthenticator{
+		secret: &vault.Secret{
+			Auth: &vault.SecretAuth{ClientToken: "k8s-token"},
+		},
+	}
+	token, err := LoginKubernetes(a, "jobservices", "header.payload.signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "k8s-token" {
+		t.Errorf("token was '%s' instead of 'k8s-token'", token)
+	}
+	if a.role != "jobservices" {
+		t.Errorf("role was '%s' instead of 'jobservices'", a.role)
+	}
+	if a.jwt != "header.payload.signature" {
+		t.Errorf("jwt was '%s' instead of 'header.payload.signature'", a.jwt)
+	}
+
+	a = &StubK8sAuthenticator{loginError: true}
+	if _, err = LoginKubernetes(a, "jobservices", "header.payload.signature"); err == nil {
+		t.Error("err was nil when the login failed")
+	}
+
+	a = &StubK8sAuthenticator{secret: &vault.Secret{}}
+	if _, err = LoginKubernetes(a, "jobservices", "header.payload.signature"); err == nil {
+		t.Error("err was nil when no auth information was returned")
+	}
+}
+
+func TestReadKubernetesJWT(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("header.payload.signature\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := readKubernetesJWT(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt != "header.payload.signature" {
+		t.Errorf("jwt was '%s' instead of 'header.payload.signature'", jwt)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err = os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readKubernetesJWT(empty); err == nil {
+		t.Error("err was nil for an empty token file")
+	}
+
+	if _, err = readKubernetesJWT(filepath.Join(dir, "missing")); err == nil {
+		t.Error("err was nil for a missing token file")
+	}
+}
+
+func TestEnableAuth(t *testing.T) {
+	e := &StubAuthEnabler{}
+	opts := &vault.EnableAuthOptions{Type: "approle", Description: "job services"}
+	if err := EnableAuth(e, "approle", opts); err != nil {
+		t.Fatal(err)
+	}
+	if e.path != "approle" {
+		t.Errorf("path was '%s' instead of 'approle'", e.path)
+	}
+	if e.options != opts {
+		t.Error("the options were not passed to EnableAuthWithOptions")
+	}
+
+	e = &StubAuthEnabler{enableError: true}
+	if err := EnableAuth(e, "approle", opts); err == nil {
+		t.Error("err was nil when enabling the auth method failed")
+	}
+}
+
+func TestIsAuthEnabled(t *testing.T) {
+	l := &StubAuthLister{}
+	for _, path := range []string{"approle", "kubernetes/"} {
+		enabled, err := IsAuthEnabled(l, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !enabled {
+			t.Errorf("%s was not enabled", path)
+		}
+	}
+
+	enabled, err := IsAuthEnabled(l, "userpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Error("userpass was enabled")
+	}
+
+	l = &StubAuthLister{returnErr: true}
+	if _, err = IsAuthEnabled(l, "approle"); err == nil {
+		t.Error("err was nil when listing the auth methods failed")
+	}
+}
@@ -1,8 +1,14 @@
 package vaulter
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
@@ -19,6 +25,45 @@ type PKIChecker interface {
 	MountWriter // this is not a mistake.
 }
 
+// ErrNoRootCA is returned when a PKI backend can't issue certs because it
+// doesn't have a root CA cert and key.
+var ErrNoRootCA = errors.New("the PKI backend has no CA certificate and key")
+
+// noRootCAMessages are the parts of the error messages that Vault has used to
+// report that a PKI backend has no CA. Older versions report a missing CA
+// certificate/key, newer versions report a missing issuer.
+var noRootCAMessages = []string{
+	"must be configured with a ca certificate/key",
+	"no default issuer",
+}
+
+// isNoRootCA returns true if the error from a PKI request means that the
+// backend doesn't have a root CA. The error messages in a *vault.ResponseError
+// are checked individually, rather than the formatted error, so that the
+// request details Vault adds to the error don't matter.
+func isNoRootCA(err error) bool {
+	if errors.Is(err, ErrNoRootCA) {
+		return true
+	}
+	msgs := []string{err.Error()}
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) {
+		if respErr.StatusCode < 400 {
+			return false
+		}
+		msgs = respErr.Errors
+	}
+	for _, msg := range msgs {
+		msg = strings.ToLower(msg)
+		for _, m := range noRootCAMessages {
+			if strings.Contains(msg, m) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // HasRootCert returns true if a cert for the provided role and common-name
 // already exists. The current process is a hack. We attempt to generate a cert,
 // if the attempt succeeds then the root cert exists.
@@ -33,7 +78,7 @@ func HasRootCert(m PKIChecker, mount, role, commonName string) (bool, error) {
 		"common_name": commonName,
 	})
 	if err != nil {
-		if strings.HasSuffix(err.Error(), "backend must be configured with a CA certificate/key") {
+		if isNoRootCA(err) {
 			return false, nil
 		}
 		return false, err
@@ -47,7 +92,48 @@ type CSRConfig struct {
 	CommonName        string
 	TTL               string
 	KeyBits           int
-	ExcludeCNFromSans bool // disables adding the common name to the list of subject alternative names
+	KeyType           string   // rsa or ec. Defaults to rsa if empty.
+	ExcludeCNFromSans bool     // disables adding the common name to the list of subject alternative names
+	AltNames          []string // DNS names and email addresses for the subject alternative names
+	IPSans            []string // IP addresses for the subject alternative names
+	URISans           []string // URIs for the subject alternative names
+}
+
+// validKeyBits lists the key_bits values Vault accepts for each key_type.
+var validKeyBits = map[string][]int{
+	"rsa": {2048, 3072, 4096},
+	"ec":  {224, 256, 384, 521},
+}
+
+// defaultKeyType is the key_type used when none is configured.
+const defaultKeyType = "rsa"
+
+// keyTypeOrDefault returns the key type, or defaultKeyType if it's empty.
+func keyTypeOrDefault(keyType string) string {
+	if keyType == "" {
+		return defaultKeyType
+	}
+	return keyType
+}
+
+// validateKeyParams returns an error if the key type isn't rsa or ec, or if the
+// key bits don't make sense for the key type. An empty key type is treated as
+// rsa, and zero key bits leaves the choice to Vault.
+func validateKeyParams(keyType string, keyBits int) error {
+	keyType = keyTypeOrDefault(keyType)
+	valid, ok := validKeyBits[keyType]
+	if !ok {
+		return fmt.Errorf("unsupported key_type %q", keyType)
+	}
+	if keyBits == 0 {
+		return nil
+	}
+	for _, b := range valid {
+		if b == keyBits {
+			return nil
+		}
+	}
+	return fmt.Errorf("key_bits %d is not valid for key_type %s, must be one of %v", keyBits, keyType, valid)
 }
 
 // ImportCert sets the signed cert for the backend mounted at the given path
@@ -62,8 +148,12 @@ func ImportCert(m MountReaderWriter, mountPath, certContents string) (*vault.Sec
 }
 
 // CSR generates a certificate signing request using the backend mounted at the
-// provided directory.
+// provided directory. The key bits are checked against the key type before
+// anything is written.
 func CSR(m MountReaderWriter, mountPath string, c *CSRConfig) (*vault.Secret, error) {
+	if err := validateKeyParams(c.KeyType, c.KeyBits); err != nil {
+		return nil, err
+	}
 	var client *vault.Client
 	client = m.Client()
 	path := fmt.Sprintf("%s/intermediate/generate/internal", mountPath)
@@ -71,8 +161,18 @@ func CSR(m MountReaderWriter, mountPath string, c *CSRConfig) (*vault.Secret, er
 		"common_name":          c.CommonName,
 		"ttl":                  c.TTL,
 		"key_bits":             c.KeyBits,
+		"key_type":             keyTypeOrDefault(c.KeyType),
 		"exclude_cn_from_sans": c.ExcludeCNFromSans,
 	}
+	if len(c.AltNames) > 0 {
+		data["alt_names"] = strings.Join(c.AltNames, ",")
+	}
+	if len(c.IPSans) > 0 {
+		data["ip_sans"] = strings.Join(c.IPSans, ",")
+	}
+	if len(c.URISans) > 0 {
+		data["uri_sans"] = strings.Join(c.URISans, ",")
+	}
 	return m.Write(client, path, data)
 }
 
@@ -81,24 +181,51 @@ type RootCACertConfig struct {
 	CommonName        string
 	TTL               string
 	KeyBits           int
+	KeyType           string // rsa or ec. Defaults to rsa if empty.
 	ExcludeCNFromSans bool
+	KeyRef            string // name or ID of an existing key to reuse instead of generating a new one
 }
 
 // RootCACert generates the root CA cert and key using the backend mounted at
-// the provided directory.
+// the provided directory. If c.KeyRef is set, the existing key it refers to is
+// used for the new root instead of generating a new key. Otherwise the key
+// bits are checked against the key type before anything is written. The
+// returned secret contains the new root cert.
 func RootCACert(m MountReaderWriter, mountPath string, c *RootCACertConfig) (*vault.Secret, error) {
 	var client *vault.Client
 	client = m.Client()
+	if c.KeyRef != "" {
+		path := fmt.Sprintf("%s/root/generate/existing", mountPath)
+		data := map[string]interface{}{
+			"common_name":          c.CommonName,
+			"ttl":                  c.TTL,
+			"key_ref":              c.KeyRef,
+			"exclude_cn_from_sans": c.ExcludeCNFromSans,
+		}
+		return m.Write(client, path, data)
+	}
+	if err := validateKeyParams(c.KeyType, c.KeyBits); err != nil {
+		return nil, err
+	}
 	path := fmt.Sprintf("%s/root/generate/internal", mountPath)
 	data := map[string]interface{}{
 		"common_name":          c.CommonName,
 		"ttl":                  c.TTL,
 		"key_bits":             c.KeyBits,
+		"key_type":             keyTypeOrDefault(c.KeyType),
 		"exclude_cn_from_sans": c.ExcludeCNFromSans,
 	}
 	return m.Write(client, path, data)
 }
 
+// DeleteRootCA deletes the root CA cert and key from the backend mounted at
+// the provided directory. Certs that were already issued stay valid until
+// they expire, but nothing new can be issued until a new root is generated or
+// imported.
+func DeleteRootCA(m MountDeleter, mountPath string) (*vault.Secret, error) {
+	return m.Delete(m.Client(), fmt.Sprintf("%s/root", mountPath))
+}
+
 // CSRSigningConfig contains the configuration settings for signing a CSR.
 type CSRSigningConfig struct {
 	CommonName string
@@ -119,14 +246,31 @@ func SignCSR(m MountReaderWriter, rootPath string, csr string, c *CSRSigningConf
 }
 
 // ConfigCAAccess sets the issuing_certificates and crl_distribution_points URLs
-// for the backend mounted at the given path.
+// for the backend mounted at the given path. If the MountReaderWriter is also a
+// PathPrefixer, the URLs point at the prefixed mount path.
 func ConfigCAAccess(m MountReaderWriter, scheme, hostPort, mountPath string) (*vault.Secret, error) {
 	var client *vault.Client
 	client = m.Client()
 	path := fmt.Sprintf("%s/config/urls", mountPath)
+	urlPath := prefixedPath(m, mountPath)
 	data := map[string]interface{}{
-		"issuing_certificates":    fmt.Sprintf("%s://%s/v1/%s/ca", scheme, hostPort, mountPath),
-		"crl_distribution_points": fmt.Sprintf("%s://%s/v1/%s/crl", scheme, hostPort, mountPath),
+		"issuing_certificates":    fmt.Sprintf("%s://%s/v1/%s/ca", scheme, hostPort, urlPath),
+		"crl_distribution_points": fmt.Sprintf("%s://%s/v1/%s/crl", scheme, hostPort, urlPath),
+	}
+	return m.Write(client, path, data)
+}
+
+// ConfigCluster sets the cluster config for the backend mounted at the given
+// path. The clusterPath is this cluster's URL for the mount, e.g.
+// https://vault-a.example.com/v1/pki, and aiaPath is the URL used in the
+// authority information access extension of issued certs. Both are needed for
+// unified CRLs and templated AIA URLs across issuers.
+func ConfigCluster(m MountReaderWriter, mountPath, clusterPath, aiaPath string) (*vault.Secret, error) {
+	client := m.Client()
+	path := fmt.Sprintf("%s/config/cluster", mountPath)
+	data := map[string]interface{}{
+		"path":     clusterPath,
+		"aia_path": aiaPath,
 	}
 	return m.Write(client, path, data)
 }
@@ -137,12 +281,26 @@ type IssueCertConfig struct {
 	AltNames          string // csv of requested subject alternative names
 	IPSans            string // csv of ip subject alternative names
 	TTL               string
-	Format            string // See the /pki/issue docs on https://www.vaultproject.io/docs/secrets/pki/ for valid values.
-	ExcludeCNFromSans bool   // exclude common name from subject alternative names
+	Format            string   // See the /pki/issue docs on https://www.vaultproject.io/docs/secrets/pki/ for valid values.
+	ExcludeCNFromSans bool     // exclude common name from subject alternative names
+	URISans           []string // URI subject alternative names, e.g. SPIFFE IDs. Must be permitted by the role's allowed_uri_sans.
+	PrivateKeyFormat  string   // der or pkcs8. Only written when set, so Vault's default is used otherwise.
+	UserIDs           []string // values for the subject's UserID (OID 0.9.2342.19200300.100.1.1). Must be permitted by the role's allowed_user_ids.
 }
 
-// IssueCert issues a cert with the given backend using the given role name.
+// IssueCert issues a cert with the given backend using the given role name. If
+// the role name is empty and the MountReaderWriter is also a RoleDefaulter, its
+// default role is used. If the backend has no root CA, the returned error wraps
+// ErrNoRootCA.
 func IssueCert(m MountReaderWriter, mountPath, roleName string, c *IssueCertConfig) (*vault.Secret, error) {
+	if rd, ok := m.(RoleDefaulter); ok && roleName == "" {
+		if d := rd.RoleDefaults(); d != nil {
+			roleName = d.Role
+		}
+	}
+	if roleName == "" {
+		return nil, errors.New("no role name was provided")
+	}
 	client := m.Client()
 	path := fmt.Sprintf("%s/issue/%s", mountPath, roleName)
 	data := map[string]interface{}{
@@ -152,6 +310,409 @@ func IssueCert(m MountReaderWriter, mountPath, roleName string, c *IssueCertConf
 		"ttl":                  c.TTL,
 		"format":               c.Format,
 		"exclude_cn_from_sans": c.ExcludeCNFromSans,
+		"uri_sans":             strings.Join(c.URISans, ","),
 	}
-	return m.Write(client, path, data)
+	if c.PrivateKeyFormat != "" {
+		data["private_key_format"] = c.PrivateKeyFormat
+	}
+	if len(c.UserIDs) > 0 {
+		data["user_ids"] = strings.Join(c.UserIDs, ",")
+	}
+	secret, err := m.Write(client, path, data)
+	if err != nil && isNoRootCA(err) {
+		return nil, fmt.Errorf("%w: %w", ErrNoRootCA, err)
+	}
+	return secret, err
+}
+
+// IssueCertForCN issues a cert the same way as IssueCert, using the role that
+// the resolver picks for the cert's common name.
+func IssueCertForCN(m MountReaderWriter, mountPath string, r *RoleResolver, c *IssueCertConfig) (*vault.Secret, error) {
+	roleName, err := r.Resolve(c.CommonName)
+	if err != nil {
+		return nil, err
+	}
+	return IssueCert(m, mountPath, roleName, c)
+}
+
+// IssuedCert contains the fields returned by Vault for an issued cert along
+// with the parsed leaf certificate.
+type IssuedCert struct {
+	Certificate  string   // PEM-encoded leaf certificate
+	PrivateKey   string   // PEM-encoded private key
+	IssuingCA    string   // PEM-encoded issuing CA certificate
+	CAChain      []string // PEM-encoded CA chain, if Vault returned one
+	SerialNumber string   // colon-separated hex, as formatted by Vault
+	Leaf         *x509.Certificate
+
+	// IssuingCASerial is the serial number of the issuing CA cert, formatted
+	// the same way as SerialNumber. Useful for telling which issuer signed a
+	// cert while issuers are being rotated.
+	IssuingCASerial string
+}
+
+// formatSerial formats a cert's serial number the way Vault does, as
+// colon-separated pairs of lowercase hex digits.
+func formatSerial(cert *x509.Certificate) string {
+	h := hex.EncodeToString(cert.SerialNumber.Bytes())
+	if len(h)%2 != 0 {
+		h = "0" + h
+	}
+	pairs := make([]string, 0, len(h)/2)
+	for i := 0; i < len(h); i += 2 {
+		pairs = append(pairs, h[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}
+
+// ParseIssuedCert parses the PEM-encoded certificate in a secret returned by
+// IssueCert, returning the parsed leaf certificate and its serial number.
+func ParseIssuedCert(secret *vault.Secret) (*x509.Certificate, string, error) {
+	if secret == nil || secret.Data == nil {
+		return nil, "", errors.New("no data was returned for the issued cert")
+	}
+	certPEM, ok := secret.Data["certificate"].(string)
+	if !ok || certPEM == "" {
+		return nil, "", errors.New("the issued cert is missing the certificate")
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, "", errors.New("the issued cert is not a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	return cert, formatSerial(cert), nil
+}
+
+// IssueCertTyped issues a cert the same way as IssueCert, but returns the
+// fields of the issued cert in an IssuedCert with the leaf already parsed.
+func IssueCertTyped(m MountReaderWriter, mountPath, roleName string, c *IssueCertConfig) (*IssuedCert, error) {
+	secret, err := IssueCert(m, mountPath, roleName, c)
+	if err != nil {
+		return nil, err
+	}
+	leaf, serial, err := ParseIssuedCert(secret)
+	if err != nil {
+		return nil, err
+	}
+	issued := &IssuedCert{
+		Leaf:         leaf,
+		SerialNumber: serial,
+	}
+	issued.Certificate, _ = secret.Data["certificate"].(string)
+	issued.PrivateKey, _ = secret.Data["private_key"].(string)
+	issued.IssuingCA, _ = secret.Data["issuing_ca"].(string)
+	if issued.IssuingCA != "" {
+		block, _ := pem.Decode([]byte(issued.IssuingCA))
+		if block == nil {
+			return nil, errors.New("the issuing CA is not PEM-encoded")
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		issued.IssuingCASerial = formatSerial(ca)
+	}
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				issued.CAChain = append(issued.CAChain, s)
+			}
+		}
+	}
+	return issued, nil
+}
+
+// VerifyCertChain verifies that the issued cert chains up to the PEM-encoded CA
+// cert, using any certs in the issued cert's CA chain as intermediates.
+func VerifyCertChain(issued *IssuedCert, caPEM string) error {
+	if issued == nil {
+		return errors.New("issued cert is nil")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(caPEM)) {
+		return errors.New("no CA certs were found in the provided PEM")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range issued.CAChain {
+		intermediates.AppendCertsFromPEM([]byte(c))
+	}
+	leaf := issued.Leaf
+	if leaf == nil {
+		block, _ := pem.Decode([]byte(issued.Certificate))
+		if block == nil {
+			return errors.New("the issued cert is not PEM-encoded")
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return err
+		}
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// GenerateTLSCertificate issues a cert for the common name with the given
+// backend and role, returning it as a tls.Certificate that's ready to be used
+// in a tls.Config. The issuing CA chain is included in the certificate chain.
+func GenerateTLSCertificate(m MountReaderWriter, mountPath, roleName, commonName string) (tls.Certificate, error) {
+	secret, err := IssueCert(m, mountPath, roleName, &IssueCertConfig{
+		CommonName: commonName,
+		Format:     "pem",
+	})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if secret == nil || secret.Data == nil {
+		return tls.Certificate{}, errors.New("no data was returned for the issued cert")
+	}
+	cert, ok := secret.Data["certificate"].(string)
+	if !ok || cert == "" {
+		return tls.Certificate{}, errors.New("the issued cert is missing the certificate")
+	}
+	key, ok := secret.Data["private_key"].(string)
+	if !ok || key == "" {
+		return tls.Certificate{}, errors.New("the issued cert is missing the private key")
+	}
+	certPEM := []string{cert}
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				certPEM = append(certPEM, s)
+			}
+		}
+	} else if ca, ok := secret.Data["issuing_ca"].(string); ok && ca != "" {
+		certPEM = append(certPEM, ca)
+	}
+	return tls.X509KeyPair([]byte(strings.Join(certPEM, "\n")), []byte(key))
+}
+
+// IsCertRevoked returns true if the cert with the given serial number issued
+// by the backend mounted at mountPath has been revoked, based on the cert's
+// revocation_time field.
+func IsCertRevoked(m MountReaderWriter, mountPath, serial string) (bool, error) {
+	client := m.Client()
+	path := fmt.Sprintf("%s/cert/%s", mountPath, serial)
+	secret, err := m.Read(client, path)
+	if err != nil {
+		return false, err
+	}
+	if secret == nil || secret.Data == nil {
+		return false, fmt.Errorf("cert %s was not found", serial)
+	}
+	v, ok := secret.Data["revocation_time"]
+	if !ok || v == nil {
+		return false, nil
+	}
+	revoked, err := dataInt(v)
+	if err != nil {
+		return false, err
+	}
+	return revoked != 0, nil
+}
+
+// RotateCRL forces the CRL for the backend mounted at mountPath to be rebuilt.
+// The returned secret reports whether the rotation succeeded.
+func RotateCRL(m MountReaderWriter, mountPath string) (*vault.Secret, error) {
+	return m.Read(m.Client(), fmt.Sprintf("%s/crl/rotate", mountPath))
+}
+
+// ReadCRL returns the PEM-encoded CRL for the backend mounted at mountPath.
+func ReadCRL(m MountReaderWriter, mountPath string) (string, error) {
+	secret, err := m.Read(m.Client(), fmt.Sprintf("%s/cert/crl", mountPath))
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("no data was returned for the CRL")
+	}
+	crlPEM, ok := secret.Data["certificate"].(string)
+	if !ok || crlPEM == "" {
+		return "", errors.New("the CRL is missing from the response")
+	}
+	return crlPEM, nil
+}
+
+// ReadPKICert returns the PEM-encoded cert with the given serial number issued
+// by the backend mounted at mountPath.
+func ReadPKICert(m MountReaderWriter, mountPath, serial string) (string, error) {
+	secret, err := m.Read(m.Client(), fmt.Sprintf("%s/cert/%s", mountPath, serial))
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("cert %s was not found", serial)
+	}
+	cert, ok := secret.Data["certificate"].(string)
+	if !ok || cert == "" {
+		return "", fmt.Errorf("the certificate for %s is missing from the response", serial)
+	}
+	return cert, nil
+}
+
+// ListPKICerts returns the serial numbers of the certs issued by the backend
+// mounted at mountPath. If nothing has been issued, an empty slice is returned.
+func ListPKICerts(l LogicalLister, mountPath string) ([]string, error) {
+	return ListPath(l, fmt.Sprintf("%s/certs", mountPath))
+}
+
+// RevokePKICertBySerial revokes the cert with the given serial number issued
+// by the backend mounted at mountPath. Use this rather than a Revoker when the
+// cert's lease ID isn't known.
+func RevokePKICertBySerial(m MountReaderWriter, mountPath, serial string) (*vault.Secret, error) {
+	return m.Write(m.Client(), fmt.Sprintf("%s/revoke", mountPath), map[string]interface{}{
+		"serial_number": serial,
+	})
+}
+
+// TidyPKI starts a tidy of the backend mounted at mountPath, removing expired
+// certificates from the cert store if tidyCertStore is set and revoked
+// certificates from the CRL if tidyRevoked is set. Only certificates that
+// expired longer than safetyBuffer ago (e.g. "72h") are removed; Vault's
+// default is used if safetyBuffer is empty. Vault runs the tidy in the
+// background, so the returned secret usually contains only a warning saying
+// that it was started rather than the results.
+func TidyPKI(m MountReaderWriter, mountPath string, tidyCertStore, tidyRevoked bool, safetyBuffer string) (*vault.Secret, error) {
+	data := map[string]interface{}{
+		"tidy_cert_store":    tidyCertStore,
+		"tidy_revoked_certs": tidyRevoked,
+	}
+	if safetyBuffer != "" {
+		data["safety_buffer"] = safetyBuffer
+	}
+	return m.Write(m.Client(), fmt.Sprintf("%s/tidy", mountPath), data)
+}
+
+// CRLNextUpdate returns the time by which the CRL for the backend mounted at
+// mountPath is due to be replaced. A CRL that's past its next update is stale.
+func CRLNextUpdate(m MountReaderWriter, mountPath string) (time.Time, error) {
+	crlPEM, err := ReadCRL(m, mountPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode([]byte(crlPEM))
+	if block == nil {
+		return time.Time{}, errors.New("the CRL is not PEM-encoded")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return crl.NextUpdate, nil
+}
+
+// PKIMigrator defines the interface for swapping a new PKI backend into the
+// place of an old one.
+type PKIMigrator interface {
+	MountLister
+	MountReaderWriter
+	Remounter
+	Unmounter
+}
+
+// PKIMigration describes a swap of a new PKI backend into the place of an old
+// one, e.g. as the last step of a CA rotation.
+type PKIMigration struct {
+	From       string // Where the new backend is mounted, e.g. pki-new.
+	To         string // Where the new backend ends up, e.g. pki.
+	Retired    string // Where the old backend is moved during the swap. Defaults to To + "-retired".
+	Role       string // The role used to check that a backend can issue certs.
+	CommonName string // The common name requested when checking the role.
+	KeepOld    bool   // Leaves the old backend mounted at Retired instead of unmounting it.
+}
+
+// checkPKIMount returns an error if the backend mounted at path isn't a PKI
+// backend that can issue certs with the role and serve its CRL.
+func checkPKIMount(m PKIMigrator, path, role, commonName string) error {
+	ok, err := IsMountedType(m, path, "pki")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a pki mount", path)
+	}
+	if ok, err = HasRootCert(m, path, role, commonName); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s: %w", path, ErrNoRootCA)
+	}
+	if _, err = ReadCRL(m, path); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// MigratePKIMount swaps the PKI backend mounted at mig.From into the place of
+// the one mounted at mig.To, keeping the data of both. The new backend must be
+// a pki mount that can issue certs with mig.Role and serve its CRL before
+// anything is moved. The old backend, if there is one, is moved to mig.Retired
+// and the new backend is moved to mig.To, where it's checked again. If a move
+// or the final check fails, the backends are moved back to where they started
+// and the error is returned. The old backend is only unmounted, destroying its
+// data, once the new one has passed its checks at mig.To, and not at all if
+// mig.KeepOld is set. Note that each check issues a cert.
+func MigratePKIMount(m PKIMigrator, mig *PKIMigration) error {
+	from, to := strings.Trim(mig.From, "/"), strings.Trim(mig.To, "/")
+	retired := strings.Trim(mig.Retired, "/")
+	if retired == "" {
+		retired = to + "-retired"
+	}
+	if err := checkPKIMount(m, from, mig.Role, mig.CommonName); err != nil {
+		return fmt.Errorf("the new backend failed its checks: %w", err)
+	}
+	old, err := findMount(m, to)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		if old.Type != "pki" {
+			return fmt.Errorf("%s is a %s mount, not a pki mount", to, old.Type)
+		}
+		var inUse bool
+		if inUse, err = IsMounted(m, retired); err != nil {
+			return err
+		}
+		if inUse {
+			return fmt.Errorf("%s is already mounted", retired)
+		}
+	}
+
+	// restore moves the backends back in the reverse order they were moved.
+	var moved [][2]string
+	restore := func(cause error) error {
+		errs := []error{cause}
+		for i := len(moved) - 1; i >= 0; i-- {
+			if rerr := m.Remount(moved[i][1], moved[i][0]); rerr != nil {
+				errs = append(errs, fmt.Errorf("failed to move %s back to %s: %w", moved[i][1], moved[i][0], rerr))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if old != nil {
+		if err = m.Remount(to, retired); err != nil {
+			return fmt.Errorf("failed to move the old backend to %s: %w", retired, err)
+		}
+		moved = append(moved, [2]string{to, retired})
+	}
+	if err = m.Remount(from, to); err != nil {
+		return restore(fmt.Errorf("failed to move the new backend to %s: %w", to, err))
+	}
+	moved = append(moved, [2]string{from, to})
+	if err = checkPKIMount(m, to, mig.Role, mig.CommonName); err != nil {
+		return restore(fmt.Errorf("the new backend failed its checks after the move: %w", err))
+	}
+	if old == nil || mig.KeepOld {
+		return nil
+	}
+	if err = m.Unmount(retired); err != nil {
+		return fmt.Errorf("the new backend is in place, but the old one couldn't be unmounted from %s: %w", retired, err)
+	}
+	return nil
 }
@@ -1,8 +1,20 @@
 package vaulter
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
@@ -56,7 +68,8 @@ func (w *StubPKIChecker) Client() *vault.Client {
 	return &vault.Client{}
 }
 
-func (w *StubPKIChecker) Write(client *vault.Client, token string, data map[string]interface{}) (*vault.Secret, error) {
+func (w *StubPKIChecker) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	w.path = path
 	w.data = data
 	secret := &vault.Secret{}
 	if w.notFoundError {
@@ -95,6 +108,79 @@ func TestHasRootCert(t *testing.T) {
 	if !hasCert {
 		t.Error("cert was not found when it should be present")
 	}
+	if cw.path != "pki/issue/example-dot-com" {
+		t.Errorf("path was '%s' instead of 'pki/issue/example-dot-com'", cw.path)
+	}
+
+	cw = &StubPKIChecker{}
+	if _, err = HasRootCert(cw, "pki-cluster-a", "example-dot-com", "test.example.com"); err != nil {
+		t.Error(err)
+	}
+	if cw.path != "pki-cluster-a/issue/example-dot-com" {
+		t.Errorf("path was '%s' instead of 'pki-cluster-a/issue/example-dot-com'", cw.path)
+	}
+	if cw.data["common_name"] != "test.example.com" {
+		t.Errorf("common_name was '%s' instead of 'test.example.com'", cw.data["common_name"])
+	}
+}
+
+type StubResponseErrorWriter struct {
+	StubMountReaderWriter
+	err error
+}
+
+func (s *StubResponseErrorWriter) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	s.path = path
+	s.data = data
+	return nil, s.err
+}
+
+func TestHasRootCertResponseError(t *testing.T) {
+	missing := []*vault.ResponseError{
+		{
+			StatusCode: http.StatusBadRequest,
+			Errors:     []string{"backend must be configured with a CA certificate/key"},
+		},
+		{
+			StatusCode: http.StatusBadRequest,
+			Errors:     []string{"no default issuer currently configured"},
+		},
+		{
+			StatusCode: http.StatusInternalServerError,
+			Errors:     []string{"1 error occurred:\n\t* Backend must be configured with a CA certificate/key\n\n"},
+		},
+	}
+	for _, respErr := range missing {
+		m := &StubResponseErrorWriter{err: respErr}
+		hasCert, err := HasRootCert(m, "pki", "example-dot-com", "test.example.com")
+		if err != nil {
+			t.Errorf("err was set for %v: %s", respErr.Errors, err)
+		}
+		if hasCert {
+			t.Errorf("cert was found for %v", respErr.Errors)
+		}
+
+		_, err = IssueCert(m, "pki", "example-dot-com", &IssueCertConfig{CommonName: "test.example.com"})
+		if !errors.Is(err, ErrNoRootCA) {
+			t.Errorf("IssueCert returned %v instead of ErrNoRootCA for %v", err, respErr.Errors)
+		}
+		var wrapped *vault.ResponseError
+		if !errors.As(err, &wrapped) {
+			t.Errorf("the response error was not wrapped for %v", respErr.Errors)
+		}
+	}
+
+	denied := &vault.ResponseError{
+		StatusCode: http.StatusBadRequest,
+		Errors:     []string{"common name test.example.com not allowed by this role"},
+	}
+	m := &StubResponseErrorWriter{err: denied}
+	if _, err := HasRootCert(m, "pki", "example-dot-com", "test.example.com"); err == nil {
+		t.Error("err was nil for an unrelated response error")
+	}
+	if _, err := IssueCert(m, "pki", "example-dot-com", &IssueCertConfig{CommonName: "test.example.com"}); errors.Is(err, ErrNoRootCA) {
+		t.Error("IssueCert returned ErrNoRootCA for an unrelated response error")
+	}
 }
 
 func TestImportCert(t *testing.T) {
@@ -179,6 +265,46 @@ func TestRootCACert(t *testing.T) {
 	}
 }
 
+func TestRootCACertExistingKey(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	cfg := &RootCACertConfig{
+		CommonName: "common.name",
+		TTL:        "forever",
+		KeyBits:    4096,
+		KeyRef:     "old-root-key",
+	}
+	s, err := RootCACert(rw, "test", cfg)
+	if err != nil {
+		t.Error(err)
+	}
+	if s == nil {
+		t.Error("s is nil")
+	}
+	expected := "test/root/generate/existing"
+	if rw.path != expected {
+		t.Errorf("path was '%s' instead of '%s'", rw.path, expected)
+	}
+	if rw.data["key_ref"] != "old-root-key" {
+		t.Errorf("key_ref was %s instead of old-root-key", rw.data["key_ref"])
+	}
+	if _, ok := rw.data["key_bits"]; ok {
+		t.Error("key_bits was set when reusing an existing key")
+	}
+
+	rw = &StubMountReaderWriter{}
+	cfg.KeyRef = ""
+	if _, err = RootCACert(rw, "test", cfg); err != nil {
+		t.Error(err)
+	}
+	expected = "test/root/generate/internal"
+	if rw.path != expected {
+		t.Errorf("path was '%s' instead of '%s'", rw.path, expected)
+	}
+	if _, ok := rw.data["key_ref"]; ok {
+		t.Error("key_ref was set when generating a new key")
+	}
+}
+
 func TestSignCSR(t *testing.T) {
 	rw := &StubMountReaderWriter{}
 	cfg := &CSRSigningConfig{
@@ -204,6 +330,24 @@ func TestSignCSR(t *testing.T) {
 	if rw.data["csr"] != expected {
 		t.Errorf("csr was %s instead of %s", rw.data["csr"], expected)
 	}
+	expected = "test/root/sign-intermediate"
+	if rw.path != expected {
+		t.Errorf("path was '%s' instead of '%s'", rw.path, expected)
+	}
+
+	m := &StubRootCAManager{}
+	s, err = SignCSR(m, "root-pki", "test-csr", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Data["certificate"] != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("certificate was %v instead of the signed cert from the response", s.Data["certificate"])
+	}
+
+	m = &StubRootCAManager{StubMountReaderWriter: StubMountReaderWriter{writeError: true}}
+	if _, err = SignCSR(m, "root-pki", "test-csr", cfg); err == nil {
+		t.Error("err was nil when the write failed")
+	}
 }
 
 func TestConfigCAAccess(t *testing.T) {
@@ -227,6 +371,33 @@ func TestConfigCAAccess(t *testing.T) {
 	}
 }
 
+func TestConfigCluster(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	s, err := ConfigCluster(rw, "pki", "https://vault-a.example.com/v1/pki", "https://pki.example.com/v1/pki")
+	if err != nil {
+		t.Error(err)
+	}
+	if s == nil {
+		t.Error("s was nil")
+	}
+	if rw.path != "pki/config/cluster" {
+		t.Errorf("path was '%s' instead of 'pki/config/cluster'", rw.path)
+	}
+	expected := "https://vault-a.example.com/v1/pki"
+	if rw.data["path"] != expected {
+		t.Errorf("path was '%s' instead of '%s'", rw.data["path"], expected)
+	}
+	expected = "https://pki.example.com/v1/pki"
+	if rw.data["aia_path"] != expected {
+		t.Errorf("aia_path was '%s' instead of '%s'", rw.data["aia_path"], expected)
+	}
+
+	rw = &StubMountReaderWriter{writeError: true}
+	if _, err = ConfigCluster(rw, "pki", "", ""); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
 func TestIssueCert(t *testing.T) {
 	rw := &StubMountReaderWriter{}
 	cfg := &IssueCertConfig{
@@ -262,4 +433,1006 @@ func TestIssueCert(t *testing.T) {
 	if !actualb {
 		t.Error("exclude_cn_from_sans was false")
 	}
+	if rw.data["uri_sans"] != "" {
+		t.Errorf("uri_sans was '%s' instead of ''", rw.data["uri_sans"])
+	}
+}
+
+func TestIssueCertURISans(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	cfg := &IssueCertConfig{
+		CommonName: "common.name",
+		URISans: []string{
+			"spiffe://example.com/ns/default/sa/foo",
+			"spiffe://example.com/ns/default/sa/bar",
+		},
+	}
+	if _, err := IssueCert(rw, "test-mount", "test-role", cfg); err != nil {
+		t.Error(err)
+	}
+	expected := "spiffe://example.com/ns/default/sa/foo,spiffe://example.com/ns/default/sa/bar"
+	if rw.data["uri_sans"] != expected {
+		t.Errorf("uri_sans was '%s' instead of '%s'", rw.data["uri_sans"], expected)
+	}
+	expected = "test-mount/issue/test-role"
+	if rw.path != expected {
+		t.Errorf("path was '%s' instead of '%s'", rw.path, expected)
+	}
+}
+
+// testCert is a cert and key generated for use in tests.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+// newTestCert generates a cert for the common name. The cert is self-signed
+// and usable as a CA if parent is nil, otherwise it's a leaf signed by parent.
+func newTestCert(t *testing.T, commonName string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{commonName},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+type StubIssuer struct {
+	StubMountReaderWriter
+	secret *vault.Secret
+}
+
+func (s *StubIssuer) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	if _, err := s.StubMountReaderWriter.Write(client, path, data); err != nil {
+		return nil, err
+	}
+	return s.secret, nil
+}
+
+func TestGenerateTLSCertificate(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	leaf := newTestCert(t, "foo.example.com", 2, ca)
+	issuer := &StubIssuer{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"certificate":   leaf.certPEM,
+				"private_key":   leaf.keyPEM,
+				"issuing_ca":    ca.certPEM,
+				"ca_chain":      []interface{}{ca.certPEM},
+				"serial_number": "02",
+			},
+		},
+	}
+	cert, err := GenerateTLSCertificate(issuer, "pki", "foo", "foo.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer.path != "pki/issue/foo" {
+		t.Errorf("path was '%s' instead of 'pki/issue/foo'", issuer.path)
+	}
+	if issuer.data["common_name"] != "foo.example.com" {
+		t.Errorf("common_name was '%s' instead of 'foo.example.com'", issuer.data["common_name"])
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("the certificate chain had %d entries instead of 2", len(cert.Certificate))
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Subject.CommonName != "foo.example.com" {
+		t.Errorf("common name was '%s' instead of 'foo.example.com'", parsed.Subject.CommonName)
+	}
+
+	issuer.secret = &vault.Secret{
+		Data: map[string]interface{}{
+			"certificate": leaf.certPEM,
+			"private_key": ca.keyPEM,
+		},
+	}
+	if _, err = GenerateTLSCertificate(issuer, "pki", "foo", "foo.example.com"); err == nil {
+		t.Error("err was nil for a mismatched key")
+	}
+
+	issuer.secret = &vault.Secret{
+		Data: map[string]interface{}{
+			"certificate": leaf.certPEM,
+		},
+	}
+	if _, err = GenerateTLSCertificate(issuer, "pki", "foo", "foo.example.com"); err == nil {
+		t.Error("err was nil for a missing key")
+	}
+
+	issuer = &StubIssuer{StubMountReaderWriter: StubMountReaderWriter{writeError: true}}
+	if _, err = GenerateTLSCertificate(issuer, "pki", "foo", "foo.example.com"); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
+type StubCertReader struct {
+	StubMountReaderWriter
+	secret *vault.Secret
+}
+
+func (s *StubCertReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	if s.readError {
+		return nil, errors.New("read error")
+	}
+	s.path = path
+	return s.secret, nil
+}
+
+func TestIsCertRevoked(t *testing.T) {
+	r := &StubCertReader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"certificate":     "cert",
+				"revocation_time": json.Number("1700000000"),
+			},
+		},
+	}
+	revoked, err := IsCertRevoked(r, "pki", "01-02-03")
+	if err != nil {
+		t.Error(err)
+	}
+	if !revoked {
+		t.Error("revoked was false for a revoked cert")
+	}
+	if r.path != "pki/cert/01-02-03" {
+		t.Errorf("path was '%s' instead of 'pki/cert/01-02-03'", r.path)
+	}
+
+	r.secret = &vault.Secret{
+		Data: map[string]interface{}{
+			"certificate":     "cert",
+			"revocation_time": json.Number("0"),
+		},
+	}
+	revoked, err = IsCertRevoked(r, "pki", "01-02-03")
+	if err != nil {
+		t.Error(err)
+	}
+	if revoked {
+		t.Error("revoked was true for an active cert")
+	}
+
+	r.secret = nil
+	if _, err = IsCertRevoked(r, "pki", "01-02-03"); err == nil {
+		t.Error("err was nil for a missing cert")
+	}
+
+	r = &StubCertReader{StubMountReaderWriter: StubMountReaderWriter{readError: true}}
+	if _, err = IsCertRevoked(r, "pki", "01-02-03"); err == nil {
+		t.Error("err was nil for a read error")
+	}
+}
+
+type StubDefaultingIssuer struct {
+	StubMountReaderWriter
+	defaults *RoleDefaults
+}
+
+func (s *StubDefaultingIssuer) RoleDefaults() *RoleDefaults {
+	return s.defaults
+}
+
+func TestIssueCertDefaultRole(t *testing.T) {
+	rw := &StubDefaultingIssuer{defaults: &RoleDefaults{Role: "htcondor"}}
+	if _, err := IssueCert(rw, "pki", "", &IssueCertConfig{CommonName: "foo.example.com"}); err != nil {
+		t.Error(err)
+	}
+	if rw.path != "pki/issue/htcondor" {
+		t.Errorf("path was '%s' instead of 'pki/issue/htcondor'", rw.path)
+	}
+
+	if _, err := IssueCert(rw, "pki", "other", &IssueCertConfig{CommonName: "foo.example.com"}); err != nil {
+		t.Error(err)
+	}
+	if rw.path != "pki/issue/other" {
+		t.Errorf("path was '%s' instead of 'pki/issue/other'", rw.path)
+	}
+
+	if _, err := IssueCert(&StubMountReaderWriter{}, "pki", "", &IssueCertConfig{}); err == nil {
+		t.Error("err was nil without a role name")
+	}
+}
+
+func TestParseIssuedCert(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	leaf := newTestCert(t, "foo.example.com", 0x1a2b3, ca)
+	secret := &vault.Secret{
+		Data: map[string]interface{}{
+			"certificate":   leaf.certPEM,
+			"private_key":   leaf.keyPEM,
+			"issuing_ca":    ca.certPEM,
+			"serial_number": "01:a2:b3",
+		},
+	}
+	cert, serial, err := ParseIssuedCert(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "foo.example.com" {
+		t.Errorf("common name was '%s' instead of 'foo.example.com'", cert.Subject.CommonName)
+	}
+	if !cert.NotAfter.Equal(leaf.cert.NotAfter) {
+		t.Errorf("expiry was %s instead of %s", cert.NotAfter, leaf.cert.NotAfter)
+	}
+	if serial != "01:a2:b3" {
+		t.Errorf("serial was '%s' instead of '01:a2:b3'", serial)
+	}
+
+	if _, _, err = ParseIssuedCert(nil); err == nil {
+		t.Error("err was nil for a nil secret")
+	}
+	if _, _, err = ParseIssuedCert(&vault.Secret{Data: map[string]interface{}{}}); err == nil {
+		t.Error("err was nil for a missing certificate")
+	}
+	secret.Data["certificate"] = leaf.keyPEM
+	if _, _, err = ParseIssuedCert(secret); err == nil {
+		t.Error("err was nil for a non-certificate PEM block")
+	}
+	secret.Data["certificate"] = "not pem"
+	if _, _, err = ParseIssuedCert(secret); err == nil {
+		t.Error("err was nil for non-PEM data")
+	}
+}
+
+func TestIssueCertTyped(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	leaf := newTestCert(t, "foo.example.com", 2, ca)
+	issuer := &StubIssuer{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"certificate":   leaf.certPEM,
+				"private_key":   leaf.keyPEM,
+				"issuing_ca":    ca.certPEM,
+				"ca_chain":      []interface{}{ca.certPEM},
+				"serial_number": "02",
+			},
+		},
+	}
+	issued, err := IssueCertTyped(issuer, "pki", "foo", &IssueCertConfig{CommonName: "foo.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issuer.path != "pki/issue/foo" {
+		t.Errorf("path was '%s' instead of 'pki/issue/foo'", issuer.path)
+	}
+	if issued.Leaf.Subject.CommonName != "foo.example.com" {
+		t.Errorf("common name was '%s' instead of 'foo.example.com'", issued.Leaf.Subject.CommonName)
+	}
+	if issued.SerialNumber != "02" {
+		t.Errorf("serial was '%s' instead of '02'", issued.SerialNumber)
+	}
+	if issued.PrivateKey != leaf.keyPEM {
+		t.Error("the private key was not set")
+	}
+	if issued.IssuingCA != ca.certPEM {
+		t.Error("the issuing CA was not set")
+	}
+	if len(issued.CAChain) != 1 || issued.CAChain[0] != ca.certPEM {
+		t.Errorf("the CA chain had %d entries instead of 1", len(issued.CAChain))
+	}
+	if issued.IssuingCASerial != "01" {
+		t.Errorf("issuing CA serial was '%s' instead of '01'", issued.IssuingCASerial)
+	}
+
+	issuer.secret.Data["issuing_ca"] = "not pem"
+	if _, err = IssueCertTyped(issuer, "pki", "foo", &IssueCertConfig{}); err == nil {
+		t.Error("err was nil for a non-PEM issuing CA")
+	}
+
+	issuer = &StubIssuer{StubMountReaderWriter: StubMountReaderWriter{writeError: true}}
+	if _, err = IssueCertTyped(issuer, "pki", "foo", &IssueCertConfig{}); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
+func TestValidateKeyParams(t *testing.T) {
+	valid := []struct {
+		keyType string
+		keyBits int
+	}{
+		{"", 0},
+		{"", 2048},
+		{"rsa", 0},
+		{"rsa", 2048},
+		{"rsa", 3072},
+		{"rsa", 4096},
+		{"ec", 0},
+		{"ec", 224},
+		{"ec", 256},
+		{"ec", 384},
+		{"ec", 521},
+	}
+	for _, v := range valid {
+		if err := validateKeyParams(v.keyType, v.keyBits); err != nil {
+			t.Errorf("key_type %q with key_bits %d was rejected: %s", v.keyType, v.keyBits, err)
+		}
+	}
+
+	invalid := []struct {
+		keyType string
+		keyBits int
+	}{
+		{"", 256},
+		{"rsa", 1024},
+		{"rsa", 384},
+		{"ec", 2048},
+		{"ec", 512},
+		{"dsa", 2048},
+	}
+	for _, v := range invalid {
+		if err := validateKeyParams(v.keyType, v.keyBits); err == nil {
+			t.Errorf("key_type %q with key_bits %d was accepted", v.keyType, v.keyBits)
+		}
+	}
+}
+
+func TestCSRKeyType(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	cfg := &CSRConfig{
+		CommonName: "common.name",
+		KeyType:    "ec",
+		KeyBits:    384,
+	}
+	if _, err := CSR(rw, "test", cfg); err != nil {
+		t.Error(err)
+	}
+	if rw.data["key_type"] != "ec" {
+		t.Errorf("key_type was %v instead of ec", rw.data["key_type"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	cfg.KeyBits = 2048
+	if _, err := CSR(rw, "test", cfg); err == nil {
+		t.Error("err was nil for an ec key with 2048 key_bits")
+	}
+	if rw.data != nil {
+		t.Error("data was written for invalid key params")
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := CSR(rw, "test", &CSRConfig{CommonName: "common.name", KeyBits: 4096}); err != nil {
+		t.Error(err)
+	}
+	if rw.data["key_type"] != "rsa" {
+		t.Errorf("key_type was %v instead of the default of rsa", rw.data["key_type"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := CSR(rw, "test", &CSRConfig{CommonName: "common.name", KeyType: "dsa"}); err == nil {
+		t.Error("err was nil for an unsupported key_type")
+	}
+	if rw.data != nil {
+		t.Error("data was written for an unsupported key_type")
+	}
+}
+
+func TestIssueCertPrivateKeyFormat(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	if _, err := IssueCert(rw, "pki", "foo", &IssueCertConfig{CommonName: "foo.example.com", PrivateKeyFormat: "pkcs8"}); err != nil {
+		t.Error(err)
+	}
+	if rw.data["private_key_format"] != "pkcs8" {
+		t.Errorf("private_key_format was %v instead of pkcs8", rw.data["private_key_format"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := IssueCert(rw, "pki", "foo", &IssueCertConfig{CommonName: "foo.example.com"}); err != nil {
+		t.Error(err)
+	}
+	if _, ok := rw.data["private_key_format"]; ok {
+		t.Error("private_key_format was set when it wasn't configured")
+	}
+}
+
+func TestVerifyCertChain(t *testing.T) {
+	root := newTestCert(t, "Test Root CA", 1, nil)
+	leaf := newTestCert(t, "foo.example.com", 2, root)
+
+	issued := &IssuedCert{Certificate: leaf.certPEM}
+	if err := VerifyCertChain(issued, root.certPEM); err != nil {
+		t.Errorf("the leaf didn't verify against its CA: %s", err)
+	}
+	issued.Leaf = leaf.cert
+	if err := VerifyCertChain(issued, root.certPEM); err != nil {
+		t.Errorf("the parsed leaf didn't verify against its CA: %s", err)
+	}
+
+	other := newTestCert(t, "Other CA", 3, nil)
+	if err := VerifyCertChain(issued, other.certPEM); err == nil {
+		t.Error("err was nil for a cert from the wrong CA")
+	}
+	if err := VerifyCertChain(issued, "not pem"); err == nil {
+		t.Error("err was nil for an invalid CA")
+	}
+	if err := VerifyCertChain(nil, root.certPEM); err == nil {
+		t.Error("err was nil for a nil cert")
+	}
+}
+
+func TestIssueCertForCN(t *testing.T) {
+	r := &RoleResolver{
+		Rules: []RoleRule{{Suffix: "cluster-a.example.com", Role: "cluster-a-role"}},
+	}
+	rw := &StubMountReaderWriter{}
+	if _, err := IssueCertForCN(rw, "pki", r, &IssueCertConfig{CommonName: "node1.cluster-a.example.com"}); err != nil {
+		t.Error(err)
+	}
+	if rw.path != "pki/issue/cluster-a-role" {
+		t.Errorf("path was '%s' instead of 'pki/issue/cluster-a-role'", rw.path)
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := IssueCertForCN(rw, "pki", r, &IssueCertConfig{CommonName: "node1.example.org"}); err == nil {
+		t.Error("err was nil for an unmatched common name")
+	}
+	if rw.data != nil {
+		t.Error("a cert was issued for an unmatched common name")
+	}
+}
+
+func TestIssueCertUserIDs(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	c := &IssueCertConfig{
+		CommonName: "worker.example.com",
+		UserIDs:    []string{"job-1234", "analysis"},
+	}
+	if _, err := IssueCert(rw, "pki", "foo", c); err != nil {
+		t.Error(err)
+	}
+	if rw.data["user_ids"] != "job-1234,analysis" {
+		t.Errorf("user_ids was %v instead of 'job-1234,analysis'", rw.data["user_ids"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := IssueCert(rw, "pki", "foo", &IssueCertConfig{CommonName: "worker.example.com"}); err != nil {
+		t.Error(err)
+	}
+	if _, ok := rw.data["user_ids"]; ok {
+		t.Error("user_ids was set when it wasn't configured")
+	}
+}
+
+func TestCRLNextUpdate(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	nextUpdate := time.Now().Add(72 * time.Hour).Truncate(time.Second).UTC()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlPEM := string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+
+	cr := &StubCertReader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{"certificate": crlPEM},
+		},
+	}
+	actual, err := CRLNextUpdate(cr, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.path != "pki/cert/crl" {
+		t.Errorf("path was '%s' instead of 'pki/cert/crl'", cr.path)
+	}
+	if !actual.Equal(nextUpdate) {
+		t.Errorf("next update was %s instead of %s", actual, nextUpdate)
+	}
+
+	cr.secret = &vault.Secret{Data: map[string]interface{}{"certificate": "not pem"}}
+	if _, err = CRLNextUpdate(cr, "pki"); err == nil {
+		t.Error("err was nil for a non-PEM CRL")
+	}
+	cr.secret = &vault.Secret{Data: map[string]interface{}{"certificate": ca.certPEM}}
+	if _, err = CRLNextUpdate(cr, "pki"); err == nil {
+		t.Error("err was nil for a cert instead of a CRL")
+	}
+	cr.secret = nil
+	if _, err = CRLNextUpdate(cr, "pki"); err == nil {
+		t.Error("err was nil for a missing CRL")
+	}
+}
+
+func TestRotateCRL(t *testing.T) {
+	cr := &StubCertReader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{"success": true},
+		},
+	}
+	secret, err := RotateCRL(cr, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.path != "pki/crl/rotate" {
+		t.Errorf("path was '%s' instead of 'pki/crl/rotate'", cr.path)
+	}
+	if secret != cr.secret {
+		t.Error("the secret from the rotation was not returned")
+	}
+
+	cr.readError = true
+	if _, err = RotateCRL(cr, "pki"); err == nil {
+		t.Error("err was nil when the rotation failed")
+	}
+}
+
+func TestReadCRL(t *testing.T) {
+	cr := &StubCertReader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{"certificate": "-----BEGIN X509 CRL-----"},
+		},
+	}
+	crl, err := ReadCRL(cr, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.path != "pki/cert/crl" {
+		t.Errorf("path was '%s' instead of 'pki/cert/crl'", cr.path)
+	}
+	if crl != "-----BEGIN X509 CRL-----" {
+		t.Errorf("crl was '%s' instead of the PEM from the response", crl)
+	}
+
+	cr.secret = &vault.Secret{Data: map[string]interface{}{}}
+	if _, err = ReadCRL(cr, "pki"); err == nil {
+		t.Error("err was nil when the CRL was missing from the response")
+	}
+	cr.secret = nil
+	if _, err = ReadCRL(cr, "pki"); err == nil {
+		t.Error("err was nil when no data was returned")
+	}
+	cr.readError = true
+	if _, err = ReadCRL(cr, "pki"); err == nil {
+		t.Error("err was nil when the read failed")
+	}
+}
+
+func TestTidyPKI(t *testing.T) {
+	tests := []struct {
+		certStore, revoked bool
+		safetyBuffer       string
+	}{
+		{true, true, "72h"},
+		{true, false, ""},
+		{false, true, "24h"},
+		{false, false, ""},
+	}
+	for _, tt := range tests {
+		rw := &StubMountReaderWriter{}
+		if _, err := TidyPKI(rw, "pki", tt.certStore, tt.revoked, tt.safetyBuffer); err != nil {
+			t.Fatal(err)
+		}
+		if rw.path != "pki/tidy" {
+			t.Errorf("path was '%s' instead of 'pki/tidy'", rw.path)
+		}
+		if rw.data["tidy_cert_store"] != tt.certStore {
+			t.Errorf("tidy_cert_store was %v instead of %v", rw.data["tidy_cert_store"], tt.certStore)
+		}
+		if rw.data["tidy_revoked_certs"] != tt.revoked {
+			t.Errorf("tidy_revoked_certs was %v instead of %v", rw.data["tidy_revoked_certs"], tt.revoked)
+		}
+		buffer, ok := rw.data["safety_buffer"]
+		if tt.safetyBuffer == "" && ok {
+			t.Errorf("safety_buffer was set to %v when it was empty", buffer)
+		}
+		if tt.safetyBuffer != "" && buffer != tt.safetyBuffer {
+			t.Errorf("safety_buffer was %v instead of %s", buffer, tt.safetyBuffer)
+		}
+	}
+
+	rw := &StubMountReaderWriter{writeError: true}
+	if _, err := TidyPKI(rw, "pki", true, true, ""); err == nil {
+		t.Error("err was nil when the write failed")
+	}
+}
+
+func TestRevokePKICertBySerial(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	if _, err := RevokePKICertBySerial(rw, "pki", "3a:5c:01"); err != nil {
+		t.Fatal(err)
+	}
+	if rw.path != "pki/revoke" {
+		t.Errorf("path was '%s' instead of 'pki/revoke'", rw.path)
+	}
+	if rw.data["serial_number"] != "3a:5c:01" {
+		t.Errorf("serial_number was %v instead of '3a:5c:01'", rw.data["serial_number"])
+	}
+
+	rw = &StubMountReaderWriter{writeError: true}
+	if _, err := RevokePKICertBySerial(rw, "pki", "3a:5c:01"); err == nil {
+		t.Error("err was nil when the write failed")
+	}
+}
+
+func TestReadPKICert(t *testing.T) {
+	cr := &StubCertReader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{"certificate": "-----BEGIN CERTIFICATE-----"},
+		},
+	}
+	cert, err := ReadPKICert(cr, "pki", "3a-5c-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.path != "pki/cert/3a-5c-01" {
+		t.Errorf("path was '%s' instead of 'pki/cert/3a-5c-01'", cr.path)
+	}
+	if cert != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("cert was '%s' instead of the PEM from the response", cert)
+	}
+
+	cr.secret = &vault.Secret{Data: map[string]interface{}{"revocation_time": 0}}
+	if _, err = ReadPKICert(cr, "pki", "3a-5c-01"); err == nil {
+		t.Error("err was nil when the certificate was missing from the response")
+	}
+	cr.secret = nil
+	if _, err = ReadPKICert(cr, "pki", "3a-5c-01"); err == nil {
+		t.Error("err was nil for a missing cert")
+	}
+	cr.readError = true
+	if _, err = ReadPKICert(cr, "pki", "3a-5c-01"); err == nil {
+		t.Error("err was nil when the read failed")
+	}
+}
+
+func TestListPKICerts(t *testing.T) {
+	l := &StubLister{keys: []interface{}{"3a-5c-01", "3a-5c-02"}}
+	serials, err := ListPKICerts(l, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.path != "pki/certs" {
+		t.Errorf("path was '%s' instead of 'pki/certs'", l.path)
+	}
+	if len(serials) != 2 || serials[0] != "3a-5c-01" || serials[1] != "3a-5c-02" {
+		t.Errorf("serials were %v instead of [3a-5c-01 3a-5c-02]", serials)
+	}
+
+	l = &StubLister{}
+	serials, err = ListPKICerts(l, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serials == nil || len(serials) != 0 {
+		t.Errorf("serials were %v instead of an empty slice", serials)
+	}
+
+	l = &StubLister{listError: true}
+	if _, err = ListPKICerts(l, "pki"); err == nil {
+		t.Error("err was nil when the list failed")
+	}
+}
+
+type StubRootCAManager struct {
+	StubMountReaderWriter
+	deleteError bool
+}
+
+func (s *StubRootCAManager) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	if _, err := s.StubMountReaderWriter.Write(client, path, data); err != nil {
+		return nil, err
+	}
+	return &vault.Secret{
+		Data: map[string]interface{}{"certificate": "-----BEGIN CERTIFICATE-----"},
+	}, nil
+}
+
+func (s *StubRootCAManager) Delete(client *vault.Client, path string) (*vault.Secret, error) {
+	s.path = path
+	if s.deleteError {
+		return nil, errors.New("delete error")
+	}
+	return nil, nil
+}
+
+func TestRootCACertKeyType(t *testing.T) {
+	m := &StubRootCAManager{}
+	cfg := &RootCACertConfig{
+		CommonName: "HTCondor Root CA",
+		TTL:        "87600h",
+		KeyBits:    384,
+		KeyType:    "ec",
+	}
+	s, err := RootCACert(m, "pki", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.path != "pki/root/generate/internal" {
+		t.Errorf("path was '%s' instead of 'pki/root/generate/internal'", m.path)
+	}
+	if m.data["key_type"] != "ec" {
+		t.Errorf("key_type was %v instead of ec", m.data["key_type"])
+	}
+	if m.data["key_bits"] != 384 {
+		t.Errorf("key_bits was %v instead of 384", m.data["key_bits"])
+	}
+	if s.Data["certificate"] != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("certificate was %v instead of the cert from the response", s.Data["certificate"])
+	}
+
+	m = &StubRootCAManager{}
+	cfg.KeyBits = 4096
+	if _, err = RootCACert(m, "pki", cfg); err == nil {
+		t.Error("err was nil for 4096 bit ec keys")
+	}
+	if m.path != "" {
+		t.Error("the root CA was generated with invalid key settings")
+	}
+
+	m = &StubRootCAManager{StubMountReaderWriter: StubMountReaderWriter{writeError: true}}
+	cfg.KeyBits = 256
+	if _, err = RootCACert(m, "pki", cfg); err == nil {
+		t.Error("err was nil when the write failed")
+	}
+}
+
+func TestDeleteRootCA(t *testing.T) {
+	m := &StubRootCAManager{}
+	if _, err := DeleteRootCA(m, "pki"); err != nil {
+		t.Fatal(err)
+	}
+	if m.path != "pki/root" {
+		t.Errorf("path was '%s' instead of 'pki/root'", m.path)
+	}
+
+	m = &StubRootCAManager{deleteError: true}
+	if _, err := DeleteRootCA(m, "pki"); err == nil {
+		t.Error("err was nil when the delete failed")
+	}
+}
+
+func TestCSRSans(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	cfg := &CSRConfig{
+		CommonName: "htcondor.example.com",
+		AltNames:   []string{"cm.example.com", "schedd.example.com"},
+		IPSans:     []string{"10.0.0.5", "10.0.0.6"},
+		URISans:    []string{"spiffe://example.com/htcondor"},
+	}
+	if _, err := CSR(rw, "pki", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if rw.data["alt_names"] != "cm.example.com,schedd.example.com" {
+		t.Errorf("alt_names was %v instead of 'cm.example.com,schedd.example.com'", rw.data["alt_names"])
+	}
+	if rw.data["ip_sans"] != "10.0.0.5,10.0.0.6" {
+		t.Errorf("ip_sans was %v instead of '10.0.0.5,10.0.0.6'", rw.data["ip_sans"])
+	}
+	if rw.data["uri_sans"] != "spiffe://example.com/htcondor" {
+		t.Errorf("uri_sans was %v instead of 'spiffe://example.com/htcondor'", rw.data["uri_sans"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	cfg = &CSRConfig{
+		CommonName: "htcondor.example.com",
+		AltNames:   []string{},
+	}
+	if _, err := CSR(rw, "pki", cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"alt_names", "ip_sans", "uri_sans"} {
+		if v, ok := rw.data[k]; ok {
+			t.Errorf("%s was set to %v when it was empty", k, v)
+		}
+	}
+}
+
+func TestRootCACertDefaultKeyType(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	if _, err := RootCACert(rw, "pki", &RootCACertConfig{CommonName: "common.name", KeyBits: 4096}); err != nil {
+		t.Fatal(err)
+	}
+	if rw.data["key_type"] != "rsa" {
+		t.Errorf("key_type was %v instead of the default of rsa", rw.data["key_type"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	if _, err := RootCACert(rw, "pki", &RootCACertConfig{CommonName: "common.name", KeyType: "dsa"}); err == nil {
+		t.Error("err was nil for an unsupported key_type")
+	}
+	if rw.data != nil {
+		t.Error("data was written for an unsupported key_type")
+	}
+}
+
+// StubPKIMigrator keeps track of which backend is mounted where, keyed by the
+// mount path, and records the remounts and unmounts.
+type StubPKIMigrator struct {
+	StubMountReaderWriter
+	mounts       map[string]string
+	ops          []string
+	noRootCA     map[string]bool
+	remountError map[string]bool
+}
+
+func newStubPKIMigrator() *StubPKIMigrator {
+	return &StubPKIMigrator{
+		mounts:       map[string]string{"pki": "old", "pki-new": "new"},
+		noRootCA:     map[string]bool{},
+		remountError: map[string]bool{},
+	}
+}
+
+func (s *StubPKIMigrator) ListMounts() (map[string]*vault.MountOutput, error) {
+	mounts := map[string]*vault.MountOutput{}
+	for path, backend := range s.mounts {
+		mounts[path+"/"] = &vault.MountOutput{Type: "pki", Description: backend}
+	}
+	return mounts, nil
+}
+
+func (s *StubPKIMigrator) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	mount := strings.SplitN(path, "/", 2)[0]
+	if s.noRootCA[mount] {
+		return nil, errors.New("backend must be configured with a CA certificate/key")
+	}
+	return s.StubMountReaderWriter.Write(client, path, data)
+}
+
+func (s *StubPKIMigrator) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	return &vault.Secret{
+		Data: map[string]interface{}{"certificate": "-----BEGIN X509 CRL-----"},
+	}, nil
+}
+
+func (s *StubPKIMigrator) Remount(from, to string) error {
+	s.ops = append(s.ops, fmt.Sprintf("remount %s %s", from, to))
+	if s.remountError[from+" "+to] {
+		return errors.New("remount error")
+	}
+	s.mounts[to] = s.mounts[from]
+	delete(s.mounts, from)
+	return nil
+}
+
+func (s *StubPKIMigrator) Unmount(path string) error {
+	s.ops = append(s.ops, fmt.Sprintf("unmount %s", path))
+	delete(s.mounts, path)
+	return nil
+}
+
+func checkPKIMigration(t *testing.T, s *StubPKIMigrator, ops []string, mounts map[string]string) {
+	t.Helper()
+	if len(s.ops) != len(ops) {
+		t.Fatalf("operations were %v instead of %v", s.ops, ops)
+	}
+	for i := range ops {
+		if s.ops[i] != ops[i] {
+			t.Errorf("operation %d was '%s' instead of '%s'", i, s.ops[i], ops[i])
+		}
+	}
+	if len(s.mounts) != len(mounts) {
+		t.Errorf("mounts were %v instead of %v", s.mounts, mounts)
+	}
+	for path, backend := range mounts {
+		if s.mounts[path] != backend {
+			t.Errorf("%s had the %s backend mounted instead of the %s backend", path, s.mounts[path], backend)
+		}
+	}
+}
+
+func TestMigratePKIMount(t *testing.T) {
+	mig := &PKIMigration{From: "pki-new", To: "pki", Role: "example-dot-com", CommonName: "test.example.com"}
+
+	s := newStubPKIMigrator()
+	if err := MigratePKIMount(s, mig); err != nil {
+		t.Fatal(err)
+	}
+	checkPKIMigration(t, s, []string{
+		"remount pki pki-retired",
+		"remount pki-new pki",
+		"unmount pki-retired",
+	}, map[string]string{"pki": "new"})
+
+	s = newStubPKIMigrator()
+	if err := MigratePKIMount(s, &PKIMigration{From: "pki-new", To: "pki", Retired: "pki-2023", KeepOld: true}); err != nil {
+		t.Fatal(err)
+	}
+	checkPKIMigration(t, s, []string{
+		"remount pki pki-2023",
+		"remount pki-new pki",
+	}, map[string]string{"pki": "new", "pki-2023": "old"})
+
+	s = newStubPKIMigrator()
+	delete(s.mounts, "pki")
+	if err := MigratePKIMount(s, mig); err != nil {
+		t.Fatal(err)
+	}
+	checkPKIMigration(t, s, []string{"remount pki-new pki"}, map[string]string{"pki": "new"})
+}
+
+func TestMigratePKIMountAborted(t *testing.T) {
+	mig := &PKIMigration{From: "pki-new", To: "pki", Role: "example-dot-com", CommonName: "test.example.com"}
+	unchanged := map[string]string{"pki": "old", "pki-new": "new"}
+
+	s := newStubPKIMigrator()
+	s.noRootCA["pki-new"] = true
+	err := MigratePKIMount(s, mig)
+	if !errors.Is(err, ErrNoRootCA) {
+		t.Errorf("err was '%v' instead of ErrNoRootCA for a new backend without a CA", err)
+	}
+	checkPKIMigration(t, s, nil, unchanged)
+
+	s = newStubPKIMigrator()
+	s.mounts["pki-retired"] = "older"
+	if err = MigratePKIMount(s, mig); err == nil {
+		t.Error("err was nil when the retired path was in use")
+	}
+	checkPKIMigration(t, s, nil, map[string]string{"pki": "old", "pki-new": "new", "pki-retired": "older"})
+
+	s = newStubPKIMigrator()
+	s.remountError["pki-new pki"] = true
+	if err = MigratePKIMount(s, mig); err == nil {
+		t.Error("err was nil when the new backend couldn't be moved")
+	}
+	checkPKIMigration(t, s, []string{
+		"remount pki pki-retired",
+		"remount pki-new pki",
+		"remount pki-retired pki",
+	}, unchanged)
+
+	// The new backend passes its checks at pki-new but fails them at pki, so
+	// both backends are moved back and the old one is never unmounted.
+	s = newStubPKIMigrator()
+	s.noRootCA["pki"] = true
+	if err = MigratePKIMount(s, mig); !errors.Is(err, ErrNoRootCA) {
+		t.Errorf("err was '%v' instead of ErrNoRootCA after the move", err)
+	}
+	checkPKIMigration(t, s, []string{
+		"remount pki pki-retired",
+		"remount pki-new pki",
+		"remount pki pki-new",
+		"remount pki-retired pki",
+	}, unchanged)
+
+	s = newStubPKIMigrator()
+	s.noRootCA["pki"] = true
+	s.remountError["pki-retired pki"] = true
+	if err = MigratePKIMount(s, mig); err == nil || !strings.Contains(err.Error(), "failed to move pki-retired back to pki") {
+		t.Errorf("err was '%v' instead of reporting the failed restore", err)
+	}
+	if s.mounts["pki-retired"] != "old" {
+		t.Error("the old backend was lost when it couldn't be moved back")
+	}
 }
@@ -59,6 +59,22 @@ type ClientWriter interface {
 	MountWriter
 }
 
+// ClientContextWriter defines the interface for writing data to a mount with a
+// context after creating a new Vault API client.
+type ClientContextWriter interface {
+	writeClientCreator
+	MountContextWriter
+}
+
+// writeClientCreator defines the interface for creating a new Vault API client
+// for writing to a mount.
+type writeClientCreator interface {
+	ClientCreator
+	ConfigGetter
+	DefaultConfigurer
+	TokenSetter
+}
+
 // ClientReader defines the interface for reading data from a mount after
 // creating a new Vault API client.
 type ClientReader interface {
@@ -67,3 +83,34 @@ type ClientReader interface {
 	TokenSetter
 	MountReader
 }
+
+// ClientContextReader defines the interface for reading data from a mount with
+// a context after creating a new Vault API client.
+type ClientContextReader interface {
+	readClientCreator
+	MountContextReader
+}
+
+// readClientCreator defines the interface for creating a new Vault API client
+// for reading from a mount.
+type readClientCreator interface {
+	ClientCreator
+	ConfigGetter
+	TokenSetter
+}
+
+// ClientLister defines the interface for listing the keys under a path after
+// creating a new Vault API client.
+type ClientLister interface {
+	ClientCreator
+	ConfigGetter
+	TokenSetter
+	PathLister
+}
+
+// ClientListReader defines the interface for listing and reading the keys under
+// a path after creating a new Vault API client.
+type ClientListReader interface {
+	ClientLister
+	MountReader
+}
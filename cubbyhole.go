@@ -0,0 +1,229 @@
+package vaulter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// CubbyholeTokenWriter defines the interface for creating a token and writing
+// to its cubbyhole, revoking the token if the write fails.
+type CubbyholeTokenWriter interface {
+	Tokener
+	TokenRevoker
+	ClientWriter
+}
+
+// CreateCubbyholeToken creates a token with the provided options and writes
+// the data to the path in its cubbyhole, returning the new token. The write
+// spends one of the token's uses if they're limited. If the write fails, the
+// token is revoked so that it isn't left behind until it expires, and the
+// write error is returned along with the revoke error if that failed too.
+func CreateCubbyholeToken(w CubbyholeTokenWriter, opts *vault.TokenCreateRequest, path string, data map[string]interface{}) (string, error) {
+	secret, err := w.CreateToken(w.Token(), opts)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", errors.New("no token was returned for the cubbyhole")
+	}
+	token := secret.Auth.ClientToken
+	if err = WriteMount(w, "cubbyhole/"+path, token, data); err != nil {
+		return "", revokeAfter(w, token, err)
+	}
+	return token, nil
+}
+
+// CubbyholeTokenReadWriter defines the interface for creating a token, writing
+// to its cubbyhole, and reading the write back.
+type CubbyholeTokenReadWriter interface {
+	CubbyholeTokenWriter
+	MountReader
+}
+
+// RotateCubbyholeConfig moves a config to a new token's cubbyhole and revokes
+// the old token. A new token is created with the provided options, the data is
+// written to the path in its cubbyhole and read back to verify it, and only
+// then is the old token revoked, so the config can always be read with one of
+// the tokens. The write and the verification read each spend one of the new
+// token's uses if they're limited, so NumUses should allow for both on top of
+// the reads the new token is meant for. If the write or the verification
+// fails, the new token is revoked and the old one is left as is. If the old
+// token can't be revoked, the new token is returned along with the error.
+func RotateCubbyholeConfig(rw CubbyholeTokenReadWriter, opts *vault.TokenCreateRequest, oldToken, path string, data map[string]interface{}) (string, error) {
+	token, err := CreateCubbyholeToken(rw, opts, path, data)
+	if err != nil {
+		return "", err
+	}
+	stored, err := ReadMount(rw, "cubbyhole/"+path, token)
+	if err == nil && !sameData(stored, data) {
+		err = fmt.Errorf("the data read back from cubbyhole/%s doesn't match what was written", path)
+	}
+	if err != nil {
+		return "", revokeAfter(rw, token, err)
+	}
+	if err = rw.RevokeToken(oldToken); err != nil {
+		return token, fmt.Errorf("revoking the old token: %w", err)
+	}
+	return token, nil
+}
+
+// sameData returns true if the data read from a secret matches the data that
+// was written. They're compared as JSON, since numbers are read back as
+// json.Numbers.
+func sameData(read, written map[string]interface{}) bool {
+	r, err := json.Marshal(read)
+	if err != nil {
+		return false
+	}
+	w, err := json.Marshal(written)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(r, w)
+}
+
+// revokeAfter revokes the token after err kept it from being handed out,
+// returning err along with the revoke error if the token couldn't be revoked.
+func revokeAfter(r TokenRevoker, token string, err error) error {
+	if revokeErr := r.RevokeToken(token); revokeErr != nil {
+		return errors.Join(err, fmt.Errorf("revoking the token: %w", revokeErr))
+	}
+	return err
+}
+
+// ReadFromCubbyhole returns the string stored under the key at the path in the
+// cubbyhole belonging to the provided token. If nothing is stored at the path,
+// or the key is missing, found is false and the error is nil. Failed reads and
+// values that aren't strings are returned as errors.
+func ReadFromCubbyhole(cr ClientReader, path, key, token string) (value string, found bool, err error) {
+	data, err := ReadMount(cr, "cubbyhole/"+strings.TrimPrefix(path, "/"), token)
+	if errors.Is(err, ErrSecretNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", false, nil
+	}
+	if value, ok = v.(string); !ok {
+		return "", false, fmt.Errorf("the value of %s at %s is a %T, not a string", key, path, v)
+	}
+	return value, true, nil
+}
+
+// MaxConcurrentCubbyholeReads is the most cubbyholes that ReadCubbyholes reads
+// at once.
+const MaxConcurrentCubbyholeReads = 10
+
+// ReadCubbyholes reads the string stored under the key at the path in the
+// cubbyhole of each of the tokens with ReadFromCubbyhole, up to
+// MaxConcurrentCubbyholeReads at a time. The values are returned keyed by
+// token; tokens with nothing stored under the key are left out. If any read
+// fails, the errors are returned in a slice the same length as tokens, where
+// the error at each index is for the token at that index and is nil if that
+// read succeeded. The slice is nil if every read succeeded.
+func ReadCubbyholes(cr ClientReader, path, key string, tokens []string) (map[string]string, []error) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed bool
+	)
+	values := make(map[string]string)
+	errs := make([]error, len(tokens))
+	sem := make(chan struct{}, MaxConcurrentCubbyholeReads)
+	for i, token := range tokens {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			value, found, err := ReadFromCubbyhole(cr, path, key, token)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[i] = err
+				failed = true
+				return
+			}
+			if found {
+				values[token] = value
+			}
+		}(i, token)
+	}
+	wg.Wait()
+	if !failed {
+		return values, nil
+	}
+	return values, errs
+}
+
+// ListCubbyhole returns the keys stored in the cubbyhole belonging to the
+// provided token. Since each token has its own cubbyhole, the listing is done
+// with a newly created client whose token is set to the one provided.
+func ListCubbyhole(l ClientLister, token string) ([]string, error) {
+	client, err := l.NewClient(l.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	l.SetToken(client, token)
+	secret, err := l.List(client, "cubbyhole/")
+	if err != nil {
+		return nil, err
+	}
+	return secretKeys(secret)
+}
+
+// ReadAllCubbyhole returns the data stored at every path in the cubbyhole
+// belonging to the provided token, keyed by path relative to the cubbyhole.
+// Folders are read recursively.
+func ReadAllCubbyhole(r ClientListReader, token string) (map[string]map[string]interface{}, error) {
+	client, err := r.NewClient(r.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	r.SetToken(client, token)
+	contents := make(map[string]map[string]interface{})
+	if err = readCubbyholeFolder(r, client, "", contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// readCubbyholeFolder reads every path under the folder in the cubbyhole into
+// contents.
+func readCubbyholeFolder(r ClientListReader, client *vault.Client, folder string, contents map[string]map[string]interface{}) error {
+	secret, err := r.List(client, "cubbyhole/"+folder)
+	if err != nil {
+		return err
+	}
+	keys, err := secretKeys(secret)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		path := folder + key
+		if strings.HasSuffix(key, "/") {
+			if err = readCubbyholeFolder(r, client, path, contents); err != nil {
+				return err
+			}
+			continue
+		}
+		secret, err := r.Read(client, "cubbyhole/"+path)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			continue
+		}
+		contents[path] = secret.Data
+	}
+	return nil
+}
@@ -0,0 +1,517 @@
+package vaulter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubCubbyholeListReader struct {
+	cfg         *vault.Config
+	token       string
+	contents    map[string]map[string]interface{}
+	clientError bool
+	listError   bool
+	readError   bool
+}
+
+func (s *StubCubbyholeListReader) GetConfig() *vault.Config {
+	return s.cfg
+}
+
+func (s *StubCubbyholeListReader) NewClient(cfg *vault.Config) (*vault.Client, error) {
+	if s.clientError {
+		return nil, errors.New("client error")
+	}
+	return &vault.Client{}, nil
+}
+
+func (s *StubCubbyholeListReader) SetToken(client *vault.Client, token string) {
+	s.token = token
+}
+
+func (s *StubCubbyholeListReader) List(client *vault.Client, path string) (*vault.Secret, error) {
+	if s.listError {
+		return nil, errors.New("list error")
+	}
+	folder := strings.TrimPrefix(path, "cubbyhole/")
+	seen := map[string]bool{}
+	keys := []interface{}{}
+	for p := range s.contents {
+		if !strings.HasPrefix(p, folder) {
+			continue
+		}
+		key := strings.TrimPrefix(p, folder)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &vault.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func (s *StubCubbyholeListReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	if s.readError {
+		return nil, errors.New("read error")
+	}
+	data, ok := s.contents[strings.TrimPrefix(path, "cubbyhole/")]
+	if !ok {
+		return nil, nil
+	}
+	return &vault.Secret{Data: data}, nil
+}
+
+func newStubCubbyhole() *StubCubbyholeListReader {
+	return &StubCubbyholeListReader{
+		cfg: &vault.Config{},
+		contents: map[string]map[string]interface{}{
+			"token":         {"irods-config": "foo"},
+			"netrc":         {"contents": "machine foo"},
+			"settings/json": {"debug": true},
+		},
+	}
+}
+
+func TestListCubbyhole(t *testing.T) {
+	s := newStubCubbyhole()
+	keys, err := ListCubbyhole(s, "token")
+	if err != nil {
+		t.Error(err)
+	}
+	if s.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", s.token)
+	}
+	if len(keys) != 3 {
+		t.Errorf("keys were %v instead of token, netrc, and settings/", keys)
+	}
+
+	s = &StubCubbyholeListReader{cfg: &vault.Config{}}
+	keys, err = ListCubbyhole(s, "token")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("keys were %v for an empty cubbyhole", keys)
+	}
+
+	s = newStubCubbyhole()
+	s.clientError = true
+	if _, err = ListCubbyhole(s, "token"); err == nil {
+		t.Error("err was nil for a client error")
+	}
+
+	s = newStubCubbyhole()
+	s.listError = true
+	if _, err = ListCubbyhole(s, "token"); err == nil {
+		t.Error("err was nil for a list error")
+	}
+}
+
+func TestReadAllCubbyhole(t *testing.T) {
+	s := newStubCubbyhole()
+	contents, err := ReadAllCubbyhole(s, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", s.token)
+	}
+	if len(contents) != 3 {
+		t.Errorf("contents were %v", contents)
+	}
+	if contents["token"]["irods-config"] != "foo" {
+		t.Errorf("irods-config was %v instead of foo", contents["token"]["irods-config"])
+	}
+	if contents["netrc"]["contents"] != "machine foo" {
+		t.Errorf("netrc was %v instead of 'machine foo'", contents["netrc"]["contents"])
+	}
+	if contents["settings/json"]["debug"] != true {
+		t.Errorf("settings/json was %v", contents["settings/json"])
+	}
+
+	s = newStubCubbyhole()
+	s.readError = true
+	if _, err = ReadAllCubbyhole(s, "token"); err == nil {
+		t.Error("err was nil for a read error")
+	}
+
+	s = newStubCubbyhole()
+	s.clientError = true
+	if _, err = ReadAllCubbyhole(s, "token"); err == nil {
+		t.Error("err was nil for a client error")
+	}
+}
+
+type StubCubbyholeTokenWriter struct {
+	StubLeaseWriter
+	created     int
+	revoked     []string
+	createError bool
+	revokeError bool
+}
+
+func (s *StubCubbyholeTokenWriter) Token() *vault.TokenAuth {
+	return &vault.TokenAuth{}
+}
+
+func (s *StubCubbyholeTokenWriter) CreateToken(ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error) {
+	if s.createError {
+		return nil, errors.New("create error")
+	}
+	s.created++
+	return &vault.Secret{
+		Auth: &vault.SecretAuth{ClientToken: fmt.Sprintf("token-%d", s.created)},
+	}, nil
+}
+
+func (s *StubCubbyholeTokenWriter) RevokeToken(token string) error {
+	s.revoked = append(s.revoked, token)
+	if s.revokeError {
+		return errors.New("revoke error")
+	}
+	return nil
+}
+
+func TestCreateCubbyholeToken(t *testing.T) {
+	w := &StubCubbyholeTokenWriter{StubLeaseWriter: StubLeaseWriter{StubCubbyholeWriter{cfg: &vault.Config{}}}}
+	data := map[string]interface{}{"irods-config": "content"}
+	token, err := CreateCubbyholeToken(w, &vault.TokenCreateRequest{NumUses: 2}, "token", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("token was '%s' instead of 'token-1'", token)
+	}
+	if w.token != "token-1" {
+		t.Errorf("the write used token '%s' instead of 'token-1'", w.token)
+	}
+	if w.path != "cubbyhole/token" {
+		t.Errorf("path was '%s' instead of 'cubbyhole/token'", w.path)
+	}
+	if w.data["irods-config"] != "content" {
+		t.Errorf("irods-config was %v instead of content", w.data["irods-config"])
+	}
+	if len(w.revoked) != 0 {
+		t.Errorf("tokens %v were revoked after a successful write", w.revoked)
+	}
+
+	// The token is revoked when the write fails.
+	w.writeError = true
+	if token, err = CreateCubbyholeToken(w, nil, "token", data); err == nil {
+		t.Fatal("err was nil for a write error")
+	}
+	if token != "" {
+		t.Errorf("token '%s' was returned for a write error", token)
+	}
+	if len(w.revoked) != 1 || w.revoked[0] != "token-2" {
+		t.Errorf("revoked tokens were %v instead of [token-2]", w.revoked)
+	}
+
+	// Both errors are returned when the revoke fails too.
+	w.revokeError = true
+	_, err = CreateCubbyholeToken(w, nil, "token", data)
+	if err == nil || !strings.Contains(err.Error(), "write error") || !strings.Contains(err.Error(), "revoke error") {
+		t.Errorf("err was '%v' instead of the write and revoke errors", err)
+	}
+
+	w = &StubCubbyholeTokenWriter{createError: true}
+	if _, err = CreateCubbyholeToken(w, nil, "token", data); err == nil {
+		t.Error("err was nil for a create error")
+	}
+	if len(w.revoked) != 0 {
+		t.Errorf("tokens %v were revoked after a create error", w.revoked)
+	}
+}
+
+type StubCubbyholeTokenReadWriter struct {
+	StubCubbyholeTokenWriter
+	readPath  string
+	readToken string
+	readError bool
+	mismatch  bool
+}
+
+// Read returns the data that was last written, decoded from JSON the way the
+// Vault client would.
+func (s *StubCubbyholeTokenReadWriter) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	s.readPath = path
+	s.readToken = s.token
+	if s.readError {
+		return nil, errors.New("read error")
+	}
+	if s.mismatch {
+		return &vault.Secret{Data: map[string]interface{}{"irods-config": "stale"}}, nil
+	}
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var data map[string]interface{}
+	if err = dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	return &vault.Secret{Data: data}, nil
+}
+
+func newStubCubbyholeTokenReadWriter() *StubCubbyholeTokenReadWriter {
+	return &StubCubbyholeTokenReadWriter{
+		StubCubbyholeTokenWriter: StubCubbyholeTokenWriter{
+			StubLeaseWriter: StubLeaseWriter{StubCubbyholeWriter{cfg: &vault.Config{}}},
+		},
+	}
+}
+
+func TestRotateCubbyholeConfig(t *testing.T) {
+	rw := newStubCubbyholeTokenReadWriter()
+	data := map[string]interface{}{"irods-config": "content", "version": 2}
+	token, err := RotateCubbyholeConfig(rw, &vault.TokenCreateRequest{NumUses: 3}, "old-token", "token", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("token was '%s' instead of 'token-1'", token)
+	}
+	if rw.path != "cubbyhole/token" || rw.readPath != "cubbyhole/token" {
+		t.Errorf("the write path was '%s' and the read path was '%s' instead of 'cubbyhole/token'", rw.path, rw.readPath)
+	}
+	if rw.readToken != "token-1" {
+		t.Errorf("the write was verified with token '%s' instead of 'token-1'", rw.readToken)
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "old-token" {
+		t.Errorf("revoked tokens were %v instead of [old-token]", rw.revoked)
+	}
+
+	// The new token is revoked and the old one kept when the write fails.
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.writeError = true
+	if token, err = RotateCubbyholeConfig(rw, nil, "old-token", "token", data); err == nil {
+		t.Error("err was nil for a write error")
+	}
+	if token != "" {
+		t.Errorf("token '%s' was returned for a write error", token)
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1]", rw.revoked)
+	}
+
+	// The same goes for a write that can't be verified.
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.mismatch = true
+	if _, err = RotateCubbyholeConfig(rw, nil, "old-token", "token", data); err == nil {
+		t.Error("err was nil for data that didn't match")
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1]", rw.revoked)
+	}
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.readError = true
+	if _, err = RotateCubbyholeConfig(rw, nil, "old-token", "token", data); err == nil {
+		t.Error("err was nil for a read error")
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1]", rw.revoked)
+	}
+
+	// The new token is still returned if the old one can't be revoked.
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.revokeError = true
+	token, err = RotateCubbyholeConfig(rw, nil, "old-token", "token", data)
+	if err == nil {
+		t.Error("err was nil for a revoke error")
+	}
+	if token != "token-1" {
+		t.Errorf("token was '%s' instead of 'token-1' after a revoke error", token)
+	}
+}
+
+func TestReadFromCubbyholeFound(t *testing.T) {
+	sr := &StubCubbyholeReader{}
+	value, found, err := ReadFromCubbyhole(sr, "config", "irods-config", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || value != "foo" {
+		t.Errorf("read ('%s', %t) instead of ('foo', true)", value, found)
+	}
+	if sr.path != "cubbyhole/config" {
+		t.Errorf("path was '%s' instead of 'cubbyhole/config'", sr.path)
+	}
+	if sr.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", sr.token)
+	}
+
+	absent := []*StubCubbyholeReader{
+		{secretError: true},
+		{noConfigError: true},
+	}
+	for i, sr := range absent {
+		value, found, err = ReadFromCubbyhole(sr, "config", "irods-config", "token")
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+		}
+		if found || value != "" {
+			t.Errorf("case %d: read ('%s', %t) instead of ('', false)", i, value, found)
+		}
+	}
+
+	failed := []*StubCubbyholeReader{
+		{readError: true},
+		{clientError: true},
+		{dataError: true},
+		{badConfigError: true},
+	}
+	for i, sr := range failed {
+		value, found, err = ReadFromCubbyhole(sr, "config", "irods-config", "token")
+		if err == nil {
+			t.Errorf("case %d: err was nil", i)
+		}
+		if found || value != "" {
+			t.Errorf("case %d: read ('%s', %t) instead of ('', false)", i, value, found)
+		}
+	}
+}
+
+// StubConcurrentCubbyholeReader serves a value per token from concurrent reads,
+// keeping track of how many reads are in flight at once.
+type StubConcurrentCubbyholeReader struct {
+	mu          sync.Mutex
+	tokens      map[*vault.Client]string
+	values      map[string]string
+	failing     map[string]bool
+	inFlight    int
+	maxInFlight int
+}
+
+func newStubConcurrentCubbyholeReader(n int) (*StubConcurrentCubbyholeReader, []string) {
+	s := &StubConcurrentCubbyholeReader{
+		tokens:  map[*vault.Client]string{},
+		values:  map[string]string{},
+		failing: map[string]bool{},
+	}
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token-%d", i)
+		s.values[tokens[i]] = fmt.Sprintf("config-%d", i)
+	}
+	return s, tokens
+}
+
+func (s *StubConcurrentCubbyholeReader) GetConfig() *vault.Config {
+	return nil
+}
+
+func (s *StubConcurrentCubbyholeReader) NewClient(cfg *vault.Config) (*vault.Client, error) {
+	return &vault.Client{}, nil
+}
+
+func (s *StubConcurrentCubbyholeReader) SetToken(client *vault.Client, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[client] = token
+}
+
+func (s *StubConcurrentCubbyholeReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	s.mu.Lock()
+	token := s.tokens[client]
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if s.failing[token] {
+		return nil, errors.New("read error")
+	}
+	value, ok := s.values[token]
+	if !ok {
+		return nil, nil
+	}
+	return &vault.Secret{Data: map[string]interface{}{"irods-config": value}}, nil
+}
+
+func TestReadCubbyholes(t *testing.T) {
+	s, tokens := newStubConcurrentCubbyholeReader(3 * MaxConcurrentCubbyholeReads)
+	values, errs := ReadCubbyholes(s, "config", "irods-config", tokens)
+	if errs != nil {
+		t.Errorf("errs were %v", errs)
+	}
+	if len(values) != len(tokens) {
+		t.Errorf("read %d values instead of %d", len(values), len(tokens))
+	}
+	for i, token := range tokens {
+		if values[token] != fmt.Sprintf("config-%d", i) {
+			t.Errorf("the value for %s was '%s' instead of 'config-%d'", token, values[token], i)
+		}
+	}
+	if s.maxInFlight > MaxConcurrentCubbyholeReads {
+		t.Errorf("%d reads were in flight at once", s.maxInFlight)
+	}
+	if s.maxInFlight < 2 {
+		t.Error("the cubbyholes were read one at a time")
+	}
+
+	values, errs = ReadCubbyholes(s, "config", "irods-config", nil)
+	if len(values) != 0 || errs != nil {
+		t.Errorf("read %v and %v for no tokens", values, errs)
+	}
+}
+
+func TestReadCubbyholesPartialFailure(t *testing.T) {
+	s, tokens := newStubConcurrentCubbyholeReader(3 * MaxConcurrentCubbyholeReads)
+	s.failing["token-3"] = true
+	s.failing["token-17"] = true
+	delete(s.values, "token-5")
+
+	values, errs := ReadCubbyholes(s, "config", "irods-config", tokens)
+	if len(errs) != len(tokens) {
+		t.Fatalf("there were %d errors instead of one per token", len(errs))
+	}
+	for i, token := range tokens {
+		switch {
+		case s.failing[token]:
+			if errs[i] == nil {
+				t.Errorf("the error for %s was nil", token)
+			}
+			if _, ok := values[token]; ok {
+				t.Errorf("a value was returned for %s after a failed read", token)
+			}
+		case token == "token-5":
+			if errs[i] != nil {
+				t.Errorf("the error for an empty cubbyhole was '%s'", errs[i])
+			}
+			if _, ok := values[token]; ok {
+				t.Error("a value was returned for an empty cubbyhole")
+			}
+		default:
+			if errs[i] != nil {
+				t.Errorf("the error for %s was '%s'", token, errs[i])
+			}
+			if values[token] != fmt.Sprintf("config-%d", i) {
+				t.Errorf("the value for %s was '%s' instead of 'config-%d'", token, values[token], i)
+			}
+		}
+	}
+	if s.maxInFlight > MaxConcurrentCubbyholeReads {
+		t.Errorf("%d reads were in flight at once", s.maxInFlight)
+	}
+}
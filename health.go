@@ -0,0 +1,81 @@
+package vaulter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// HealthChecker is an interface for objects that can check the health of the
+// Vault server.
+type HealthChecker interface {
+	Health() (*vault.HealthResponse, error)
+}
+
+// Health returns the health status of the Vault server. The health endpoint
+// doesn't require a valid token, so this works as a readiness check before the
+// token is known to be good.
+func Health(h HealthChecker) (*vault.HealthResponse, error) {
+	return h.Health()
+}
+
+// IsInitialized returns true if the Vault server has been initialized.
+func IsInitialized(h HealthChecker) (bool, error) {
+	resp, err := h.Health()
+	if err != nil {
+		return false, err
+	}
+	if resp == nil {
+		return false, errors.New("no health status was returned")
+	}
+	return resp.Initialized, nil
+}
+
+// ClockSkew returns how far the Vault server's clock is ahead of the local
+// clock, based on the server time reported by the health endpoint. A negative
+// value means the server's clock is behind. The server time only has a
+// resolution of one second.
+func ClockSkew(h HealthChecker) (time.Duration, error) {
+	resp, err := h.Health()
+	if err != nil {
+		return 0, err
+	}
+	if resp == nil || resp.ServerTimeUTC == 0 {
+		return 0, errors.New("the health response did not include the server time")
+	}
+	return time.Unix(resp.ServerTimeUTC, 0).Sub(time.Now()), nil
+}
+
+// selfTestPath is where SelfTest writes its probe in the cubbyhole.
+const selfTestPath = "self-test"
+
+// SelfTest checks the path a config takes through Vault from end to end. It
+// creates a short-lived token, writes a probe value to the token's cubbyhole,
+// reads it back, and returns an error if it doesn't match. The token is always
+// revoked afterwards. Returns how long the check took, not counting the
+// revoke, so that it can be used as a canary for latency as well as
+// availability.
+func SelfTest(rw CubbyholeTokenReadWriter) (time.Duration, error) {
+	start := time.Now()
+	probe := strconv.FormatInt(start.UnixNano(), 10)
+	opts := &vault.TokenCreateRequest{TTL: "1m", DisplayName: "self-test"}
+	token, err := CreateCubbyholeToken(rw, opts, selfTestPath, map[string]interface{}{"probe": probe})
+	if err != nil {
+		return time.Since(start), err
+	}
+	data, err := ReadMount(rw, "cubbyhole/"+selfTestPath, token)
+	elapsed := time.Since(start)
+	if err == nil && data["probe"] != probe {
+		err = fmt.Errorf("the probe read back was %v instead of %s", data["probe"], probe)
+	}
+	if err != nil {
+		return elapsed, revokeAfter(rw, token, err)
+	}
+	if err = rw.RevokeToken(token); err != nil {
+		return elapsed, fmt.Errorf("revoking the self-test token: %w", err)
+	}
+	return elapsed, nil
+}
@@ -0,0 +1,141 @@
+package vaulter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubHealthChecker struct {
+	resp        *vault.HealthResponse
+	healthError bool
+}
+
+func (s *StubHealthChecker) Health() (*vault.HealthResponse, error) {
+	if s.healthError {
+		return nil, errors.New("health error")
+	}
+	return s.resp, nil
+}
+
+func TestClockSkew(t *testing.T) {
+	h := &StubHealthChecker{
+		resp: &vault.HealthResponse{
+			ServerTimeUTC: time.Now().Add(10 * time.Minute).Unix(),
+		},
+	}
+	skew, err := ClockSkew(h)
+	if err != nil {
+		t.Error(err)
+	}
+	if skew < 9*time.Minute+58*time.Second || skew > 10*time.Minute+time.Second {
+		t.Errorf("skew was %s instead of roughly 10m", skew)
+	}
+
+	h.resp.ServerTimeUTC = time.Now().Add(-5 * time.Minute).Unix()
+	skew, err = ClockSkew(h)
+	if err != nil {
+		t.Error(err)
+	}
+	if skew > -4*time.Minute-58*time.Second || skew < -5*time.Minute-time.Second {
+		t.Errorf("skew was %s instead of roughly -5m", skew)
+	}
+
+	h.resp.ServerTimeUTC = 0
+	if _, err = ClockSkew(h); err == nil {
+		t.Error("err was nil for a missing server time")
+	}
+
+	h = &StubHealthChecker{healthError: true}
+	if _, err = ClockSkew(h); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	h := &StubHealthChecker{
+		resp: &vault.HealthResponse{Initialized: true, Sealed: false, Version: "1.15.2"},
+	}
+	resp, err := Health(h)
+	if err != nil {
+		t.Error(err)
+	}
+	if !resp.Initialized || resp.Sealed {
+		t.Errorf("health was %+v", resp)
+	}
+
+	if _, err = Health(&StubHealthChecker{healthError: true}); err == nil {
+		t.Error("err was nil for a health error")
+	}
+}
+
+func TestIsInitialized(t *testing.T) {
+	initialized, err := IsInitialized(&StubHealthChecker{resp: &vault.HealthResponse{Initialized: true}})
+	if err != nil {
+		t.Error(err)
+	}
+	if !initialized {
+		t.Error("initialized was false for an initialized server")
+	}
+
+	initialized, err = IsInitialized(&StubHealthChecker{resp: &vault.HealthResponse{}})
+	if err != nil {
+		t.Error(err)
+	}
+	if initialized {
+		t.Error("initialized was true for an uninitialized server")
+	}
+
+	if _, err = IsInitialized(&StubHealthChecker{healthError: true}); err == nil {
+		t.Error("err was nil for a health error")
+	}
+	if _, err = IsInitialized(&StubHealthChecker{}); err == nil {
+		t.Error("err was nil for a missing health response")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	rw := newStubCubbyholeTokenReadWriter()
+	elapsed, err := SelfTest(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed < 0 {
+		t.Errorf("elapsed was %s", elapsed)
+	}
+	if rw.path != "cubbyhole/self-test" || rw.readPath != "cubbyhole/self-test" {
+		t.Errorf("the write path was '%s' and the read path was '%s' instead of 'cubbyhole/self-test'", rw.path, rw.readPath)
+	}
+	if rw.token != "token-1" || rw.readToken != "token-1" {
+		t.Errorf("the probe was written with '%s' and read with '%s' instead of 'token-1'", rw.token, rw.readToken)
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1]", rw.revoked)
+	}
+
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.mismatch = true
+	if _, err = SelfTest(rw); err == nil {
+		t.Error("err was nil when the probe didn't match")
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1] after a mismatch", rw.revoked)
+	}
+
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.writeError = true
+	if _, err = SelfTest(rw); err == nil {
+		t.Error("err was nil for a write error")
+	}
+	if len(rw.revoked) != 1 || rw.revoked[0] != "token-1" {
+		t.Errorf("revoked tokens were %v instead of [token-1] after a write error", rw.revoked)
+	}
+
+	rw = newStubCubbyholeTokenReadWriter()
+	rw.revokeError = true
+	if _, err = SelfTest(rw); err == nil {
+		t.Error("err was nil for a revoke error")
+	}
+}
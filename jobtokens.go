@@ -0,0 +1,186 @@
+package vaulter
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Tokener is an interface for objects that can create child or orphan tokens.
+type Tokener interface {
+	Token() *vault.TokenAuth
+	CreateToken(ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error)
+}
+
+// DefaultJobTokenExpiryMargin is the ExpiryMargin used by NewJobTokenCache.
+const DefaultJobTokenExpiryMargin = 30 * time.Second
+
+// JobTokenCache hands out one token per job ID and Tokener, reusing a job's
+// token until it is about to expire so that retried jobs don't create new
+// tokens. Tokens created through different Tokeners, e.g. for different Vault
+// servers or parent tokens, are cached separately, so the Tokeners must be
+// comparable, such as a *VaultAPI. Options are used when creating each token
+// and may be nil. Tokens with limited uses (Options.NumUses > 0) are never
+// reused, since a retried job may have already spent them. The zero value is
+// ready to use with no options and no expiry margin. It's safe for concurrent
+// use.
+type JobTokenCache struct {
+	Options *vault.TokenCreateRequest
+
+	// ExpiryMargin is how long before a cached token expires that it stops
+	// being handed out, so that jobs don't get a token that's about to expire.
+	ExpiryMargin time.Duration
+
+	mu      sync.Mutex
+	tokens  map[jobTokenKey]jobToken
+	pending map[jobTokenKey]*jobTokenCall
+	now     func() time.Time
+}
+
+// jobTokenKey identifies a job's token in the cache.
+type jobTokenKey struct {
+	tokener Tokener
+	jobID   string
+}
+
+// jobToken is a cached token and the time it expires. A zero expiry means the
+// token doesn't expire.
+type jobToken struct {
+	token   string
+	expires time.Time
+}
+
+// expired returns true if the token expires within margin of now.
+func (jt jobToken) expired(now time.Time, margin time.Duration) bool {
+	return !jt.expires.IsZero() && !now.Add(margin).Before(jt.expires)
+}
+
+// jobTokenCall tracks the creation of a job's token that is in flight.
+type jobTokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewJobTokenCache returns a *JobTokenCache that creates tokens with the
+// provided options.
+func NewJobTokenCache(opts *vault.TokenCreateRequest) *JobTokenCache {
+	return &JobTokenCache{
+		Options:      opts,
+		ExpiryMargin: DefaultJobTokenExpiryMargin,
+	}
+}
+
+// clock returns the current time.
+func (c *JobTokenCache) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// init creates the cache's maps if they haven't been yet. c.mu must be held.
+func (c *JobTokenCache) init() {
+	if c.tokens == nil {
+		c.tokens = make(map[jobTokenKey]jobToken)
+	}
+	if c.pending == nil {
+		c.pending = make(map[jobTokenKey]*jobTokenCall)
+	}
+}
+
+// TokenForJob returns the token cached for the job ID and Tokener if it isn't
+// about to expire, otherwise it creates a new token with the Tokener and caches
+// it. Calls for a job whose
+// token is already being created wait for it and share it. Expired tokens are
+// removed from the cache whenever a new token is cached. The job ID is added to
+// the token's metadata as job_id.
+func (c *JobTokenCache) TokenForJob(t Tokener, jobID string) (string, error) {
+	if c.Options != nil && c.Options.NumUses > 0 {
+		jt, err := c.createToken(t, jobID, c.clock())
+		return jt.token, err
+	}
+
+	key := jobTokenKey{tokener: t, jobID: jobID}
+	c.mu.Lock()
+	c.init()
+	now := c.clock()
+	if jt, ok := c.tokens[key]; ok && !jt.expired(now, c.ExpiryMargin) {
+		c.mu.Unlock()
+		return jt.token, nil
+	}
+	if call, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &jobTokenCall{done: make(chan struct{})}
+	c.pending[key] = call
+	c.mu.Unlock()
+
+	jt, err := c.createToken(t, jobID, now)
+	call.token, call.err = jt.token, err
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	if err == nil {
+		c.evictExpired()
+		c.tokens[key] = jt
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+// Forget removes the job's tokens from the cache, e.g. once the job is done.
+// The tokens themselves aren't revoked.
+func (c *JobTokenCache) Forget(jobID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.tokens {
+		if key.jobID == jobID {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+// evictExpired removes the expired tokens from the cache. c.mu must be held.
+func (c *JobTokenCache) evictExpired() {
+	now := c.clock()
+	for key, jt := range c.tokens {
+		if jt.expired(now, 0) {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+// createToken creates a token for the job. The token's expiry is counted from
+// now, which should be taken before the token is requested.
+func (c *JobTokenCache) createToken(t Tokener, jobID string, now time.Time) (jobToken, error) {
+	opts := &vault.TokenCreateRequest{}
+	if c.Options != nil {
+		*opts = *c.Options
+	}
+	metadata := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata["job_id"] = jobID
+	opts.Metadata = metadata
+
+	secret, err := t.CreateToken(t.Token(), opts)
+	if err != nil {
+		return jobToken{}, err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return jobToken{}, errors.New("no token was returned for job " + jobID)
+	}
+	jt := jobToken{token: secret.Auth.ClientToken}
+	if secret.Auth.LeaseDuration > 0 {
+		jt.expires = now.Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	}
+	return jt, nil
+}
@@ -0,0 +1,264 @@
+package vaulter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubTokener struct {
+	mu            sync.Mutex
+	created       int
+	opts          *vault.TokenCreateRequest
+	leaseDuration int
+	createError   bool
+}
+
+func (s *StubTokener) Token() *vault.TokenAuth {
+	return &vault.TokenAuth{}
+}
+
+func (s *StubTokener) CreateToken(ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.createError {
+		return nil, errors.New("create error")
+	}
+	s.created++
+	s.opts = opts
+	return &vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken:   fmt.Sprintf("token-%d", s.created),
+			LeaseDuration: s.leaseDuration,
+		},
+	}, nil
+}
+
+func TestJobTokenCache(t *testing.T) {
+	now := time.Now()
+	c := NewJobTokenCache(&vault.TokenCreateRequest{
+		Policies: []string{"job"},
+		Metadata: map[string]string{"service": "orchestrator"},
+	})
+	c.now = func() time.Time { return now }
+	tokener := &StubTokener{leaseDuration: 60}
+
+	token, err := c.TokenForJob(tokener, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("token was '%s' instead of 'token-1'", token)
+	}
+	if tokener.opts.Metadata["job_id"] != "job-1" {
+		t.Errorf("job_id was '%s' instead of 'job-1'", tokener.opts.Metadata["job_id"])
+	}
+	if tokener.opts.Metadata["service"] != "orchestrator" {
+		t.Errorf("service was '%s' instead of 'orchestrator'", tokener.opts.Metadata["service"])
+	}
+	if len(tokener.opts.Policies) != 1 || tokener.opts.Policies[0] != "job" {
+		t.Errorf("policies were %v instead of [job]", tokener.opts.Policies)
+	}
+	if _, ok := c.Options.Metadata["job_id"]; ok {
+		t.Error("the cache's options were modified")
+	}
+
+	// Cache hit.
+	if token, err = c.TokenForJob(tokener, "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" || tokener.created != 1 {
+		t.Errorf("token was '%s' after %d creates instead of a cache hit", token, tokener.created)
+	}
+
+	// Cache miss.
+	if token, err = c.TokenForJob(tokener, "job-2"); err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-2" {
+		t.Errorf("token was '%s' instead of 'token-2'", token)
+	}
+
+	// Expiry.
+	now = now.Add(61 * time.Second)
+	if token, err = c.TokenForJob(tokener, "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-3" {
+		t.Errorf("token was '%s' instead of 'token-3' after expiring", token)
+	}
+
+	// Within the expiry margin.
+	now = now.Add(31 * time.Second)
+	if token, err = c.TokenForJob(tokener, "job-1"); err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-4" {
+		t.Errorf("token was '%s' instead of 'token-4' within the expiry margin", token)
+	}
+
+	// job-2's token expired and was evicted when job-1's new token was cached.
+	if _, ok := c.tokens[jobTokenKey{tokener, "job-2"}]; ok {
+		t.Error("the expired token for job-2 was not evicted")
+	}
+	c.Forget("job-1")
+	if len(c.tokens) != 0 {
+		t.Errorf("the cache had %d tokens instead of 0", len(c.tokens))
+	}
+
+	tokener.createError = true
+	if _, err = c.TokenForJob(tokener, "job-3"); err == nil {
+		t.Error("err was nil for a create error")
+	}
+	if _, ok := c.tokens[jobTokenKey{tokener, "job-3"}]; ok {
+		t.Error("a token was cached for a create error")
+	}
+}
+
+func TestJobTokenCacheZeroValue(t *testing.T) {
+	c := &JobTokenCache{}
+	tokener := &StubTokener{leaseDuration: 60}
+	for i := 0; i < 2; i++ {
+		token, err := c.TokenForJob(tokener, "job-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("token was '%s' instead of 'token-1'", token)
+		}
+	}
+	c.Forget("job-1")
+	if len(c.tokens) != 0 {
+		t.Errorf("the cache had %d tokens instead of 0", len(c.tokens))
+	}
+	(&JobTokenCache{}).Forget("job-1")
+}
+
+func TestJobTokenCacheTokeners(t *testing.T) {
+	c := NewJobTokenCache(nil)
+	a := &StubTokener{}
+	b := &StubTokener{}
+	tokenA, err := c.TokenForJob(a, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenB, err := c.TokenForJob(b, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.created != 1 || b.created != 1 {
+		t.Errorf("%d and %d tokens were created instead of one for each Tokener", a.created, b.created)
+	}
+	if tokenA == "" || tokenB == "" {
+		t.Errorf("the tokens were '%s' and '%s'", tokenA, tokenB)
+	}
+	if again, _ := c.TokenForJob(a, "job-1"); again != tokenA || a.created != 1 {
+		t.Errorf("token was '%s' after %d creates instead of a cache hit", again, a.created)
+	}
+
+	c.Forget("job-1")
+	if len(c.tokens) != 0 {
+		t.Errorf("the cache had %d tokens instead of 0 after forgetting the job", len(c.tokens))
+	}
+}
+
+func TestJobTokenCacheLimitedUses(t *testing.T) {
+	c := NewJobTokenCache(&vault.TokenCreateRequest{NumUses: 2})
+	tokener := &StubTokener{leaseDuration: 3600}
+	for i, expected := range []string{"token-1", "token-2"} {
+		token, err := c.TokenForJob(tokener, "job-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != expected {
+			t.Errorf("call %d returned '%s' instead of '%s'", i+1, token, expected)
+		}
+	}
+	if tokener.opts.NumUses != 2 {
+		t.Errorf("num_uses was %d instead of 2", tokener.opts.NumUses)
+	}
+	if len(c.tokens) != 0 {
+		t.Errorf("%d limited-use tokens were cached", len(c.tokens))
+	}
+}
+
+// StubBlockingTokener is a Tokener whose CreateToken blocks for the "slow"
+// job until release is closed.
+type StubBlockingTokener struct {
+	StubTokener
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *StubBlockingTokener) CreateToken(ta *vault.TokenAuth, opts *vault.TokenCreateRequest) (*vault.Secret, error) {
+	if opts.Metadata["job_id"] == "slow" {
+		close(s.entered)
+		<-s.release
+	}
+	return s.StubTokener.CreateToken(ta, opts)
+}
+
+func TestJobTokenCacheUnlockedCreate(t *testing.T) {
+	c := NewJobTokenCache(nil)
+	tokener := &StubBlockingTokener{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	slow := make(chan error, 1)
+	go func() {
+		_, err := c.TokenForJob(tokener, "slow")
+		slow <- err
+	}()
+	<-tokener.entered
+
+	fast := make(chan error, 1)
+	go func() {
+		_, err := c.TokenForJob(tokener, "fast")
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating a token for one job blocked on another job")
+	}
+
+	close(tokener.release)
+	if err := <-slow; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobTokenCacheConcurrent(t *testing.T) {
+	c := NewJobTokenCache(nil)
+	tokener := &StubTokener{}
+	var wg sync.WaitGroup
+	tokens := make(chan string, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := c.TokenForJob(tokener, "job-1")
+			if err != nil {
+				t.Error(err)
+			}
+			tokens <- token
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+	for token := range tokens {
+		if token != "token-1" {
+			t.Errorf("token was '%s' instead of 'token-1'", token)
+		}
+	}
+	if tokener.created != 1 {
+		t.Errorf("%d tokens were created instead of 1", tokener.created)
+	}
+}
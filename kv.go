@@ -0,0 +1,63 @@
+package vaulter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kv2Path returns the path to a secret under the section of a KV version 2
+// mount, e.g. "data" or "metadata".
+func kv2Path(mount, section, secretPath string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.Trim(mount, "/"), section, strings.Trim(secretPath, "/"))
+}
+
+// ReadKV2Version returns the data stored in a version of a secret in a KV
+// version 2 mount. Returns an error if the version doesn't exist or has been
+// deleted or destroyed.
+func ReadKV2Version(r KV2Reader, mount, secretPath string, version int) (map[string]interface{}, error) {
+	path := kv2Path(mount, "data", secretPath)
+	secret, err := r.ReadWithData(r.Client(), path, map[string][]string{
+		"version": {strconv.Itoa(version)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("version %d of %s was not found", version, path)
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("version %d of %s has no data, it may have been deleted", version, path)
+	}
+	return data, nil
+}
+
+// ListKV2Versions returns the version numbers of a secret in a KV version 2
+// mount in ascending order, read from the secret's metadata. Deleted and
+// destroyed versions are included.
+func ListKV2Versions(r KV2Reader, mount, secretPath string) ([]int, error) {
+	path := kv2Path(mount, "metadata", secretPath)
+	secret, err := r.Read(r.Client(), path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no metadata was found for %s", path)
+	}
+	versions, ok := secret.Data["versions"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the metadata for %s has no versions", path)
+	}
+	list := make([]int, 0, len(versions))
+	for k := range versions {
+		v, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("version %s of %s is not a number", k, path)
+		}
+		list = append(list, v)
+	}
+	sort.Ints(list)
+	return list, nil
+}
@@ -0,0 +1,114 @@
+package vaulter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubKV2Reader struct {
+	path      string
+	query     map[string][]string
+	secret    *vault.Secret
+	readError bool
+}
+
+func (s *StubKV2Reader) Client() *vault.Client {
+	return nil
+}
+
+func (s *StubKV2Reader) Read(c *vault.Client, path string) (*vault.Secret, error) {
+	return s.ReadWithData(c, path, nil)
+}
+
+func (s *StubKV2Reader) ReadWithData(c *vault.Client, path string, data map[string][]string) (*vault.Secret, error) {
+	s.path = path
+	s.query = data
+	if s.readError {
+		return nil, errors.New("read error")
+	}
+	return s.secret, nil
+}
+
+func TestReadKV2Version(t *testing.T) {
+	r := &StubKV2Reader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"data":     map[string]interface{}{"password": "old"},
+				"metadata": map[string]interface{}{"version": 2},
+			},
+		},
+	}
+	data, err := ReadKV2Version(r, "secret/", "/jobs/config", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.path != "secret/data/jobs/config" {
+		t.Errorf("path was '%s' instead of 'secret/data/jobs/config'", r.path)
+	}
+	if !reflect.DeepEqual(r.query, map[string][]string{"version": {"2"}}) {
+		t.Errorf("query was %v instead of version=2", r.query)
+	}
+	if data["password"] != "old" {
+		t.Errorf("password was '%v' instead of 'old'", data["password"])
+	}
+
+	r = &StubKV2Reader{}
+	if _, err = ReadKV2Version(r, "secret", "jobs/config", 7); err == nil {
+		t.Error("err was nil for a missing version")
+	}
+
+	r = &StubKV2Reader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"data":     nil,
+				"metadata": map[string]interface{}{"deletion_time": "2026-10-01T00:00:00Z"},
+			},
+		},
+	}
+	if _, err = ReadKV2Version(r, "secret", "jobs/config", 1); err == nil {
+		t.Error("err was nil for a deleted version")
+	}
+
+	r = &StubKV2Reader{readError: true}
+	if _, err = ReadKV2Version(r, "secret", "jobs/config", 1); err == nil {
+		t.Error("err was nil when the read failed")
+	}
+}
+
+func TestListKV2Versions(t *testing.T) {
+	r := &StubKV2Reader{
+		secret: &vault.Secret{
+			Data: map[string]interface{}{
+				"current_version": 10,
+				"versions": map[string]interface{}{
+					"10": map[string]interface{}{},
+					"2":  map[string]interface{}{},
+					"1":  map[string]interface{}{"destroyed": true},
+				},
+			},
+		},
+	}
+	versions, err := ListKV2Versions(r, "secret", "jobs/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.path != "secret/metadata/jobs/config" {
+		t.Errorf("path was '%s' instead of 'secret/metadata/jobs/config'", r.path)
+	}
+	if !reflect.DeepEqual(versions, []int{1, 2, 10}) {
+		t.Errorf("versions were %v instead of [1 2 10]", versions)
+	}
+
+	r = &StubKV2Reader{}
+	if _, err = ListKV2Versions(r, "secret", "jobs/config"); err == nil {
+		t.Error("err was nil for a missing secret")
+	}
+
+	r = &StubKV2Reader{readError: true}
+	if _, err = ListKV2Versions(r, "secret", "jobs/config"); err == nil {
+		t.Error("err was nil when the read failed")
+	}
+}
@@ -0,0 +1,171 @@
+package vaulter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// LeaseExpiry returns the absolute time at which the lease on the secret
+// expires and how much time remains on it, based on the time the secret was
+// issued. Returns an error if the secret is nil or doesn't carry a lease.
+func LeaseExpiry(secret *vault.Secret, issuedAt time.Time) (time.Time, time.Duration, error) {
+	if secret == nil {
+		return time.Time{}, 0, errors.New("secret is nil")
+	}
+	if secret.LeaseDuration <= 0 {
+		return time.Time{}, 0, errors.New("secret does not have a lease duration")
+	}
+	expiry := issuedAt.Add(time.Duration(secret.LeaseDuration) * time.Second)
+	return expiry, time.Until(expiry), nil
+}
+
+// defaultSystemMaxLeaseTTL is the max_lease_ttl Vault uses when one isn't set
+// in the server's configuration.
+const defaultSystemMaxLeaseTTL = 768 * time.Hour
+
+// SystemMaxLeaseTTL returns the system-wide max_lease_ttl from Vault's
+// sanitized server configuration. Lease and cert TTLs requested beyond it are
+// silently clamped. If the server configuration doesn't set one, Vault's
+// built-in default of 32 days is returned.
+func SystemMaxLeaseTTL(m MountReaderWriter) (time.Duration, error) {
+	secret, err := m.Read(m.Client(), "sys/config/state/sanitized")
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, errors.New("no data was returned for the sanitized config")
+	}
+	v, ok := secret.Data["max_lease_ttl"]
+	if !ok || v == nil {
+		return defaultSystemMaxLeaseTTL, nil
+	}
+	secs, err := dataInt(v)
+	if err != nil {
+		return 0, err
+	}
+	if secs == 0 {
+		return defaultSystemMaxLeaseTTL, nil
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// TokenRenewer is an interface for objects that can renew the lease on a
+// secret.
+type TokenRenewer interface {
+	Renew(c *vault.Client, leaseID string, increment int) (*vault.Secret, error)
+}
+
+// renewInterval returns how long to wait before renewing a lease with the given
+// duration in seconds. A variable so tests don't have to wait on real leases.
+var renewInterval = func(leaseDuration int) time.Duration {
+	return time.Duration(leaseDuration) * time.Second / 2
+}
+
+// ErrLeaseNotExtended is reported by ReadAndWatch when a renewal succeeds but
+// Vault doesn't grant any more time on the lease, usually because it has
+// reached its max TTL.
+var ErrLeaseNotExtended = errors.New("the lease was not extended")
+
+// ReadAndWatch reads the secret at path using a new client with the provided
+// token. If the secret carries a renewable lease, a background goroutine renews
+// the lease at half of its duration until ctx is cancelled. Renewal stops for
+// good if a renewal fails or Vault stops granting time on the lease.
+//
+// The returned channel is closed when renewal stops. If it stopped because a
+// renewal failed, the error is sent on the channel first, or
+// ErrLeaseNotExtended if Vault didn't grant any more time. Nothing is sent if
+// ctx was cancelled. The channel is closed right away if the secret isn't
+// renewable. It's buffered, so callers that don't care may ignore it.
+func ReadAndWatch(ctx context.Context, cr ClientReader, path, token string, renew TokenRenewer) (*vault.Secret, <-chan error, error) {
+	client, err := cr.NewClient(cr.GetConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	cr.SetToken(client, token)
+	secret, err := cr.Read(client, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret == nil {
+		return nil, nil, errors.New("no secret was found at " + path)
+	}
+	done := make(chan error, 1)
+	if secret.Renewable && secret.LeaseID != "" && secret.LeaseDuration > 0 {
+		go func() {
+			defer close(done)
+			if err := watchLease(ctx, client, renew, secret.LeaseID, secret.LeaseDuration, renewInterval); err != nil {
+				done <- err
+			}
+		}()
+	} else {
+		close(done)
+	}
+	return secret, done, nil
+}
+
+// watchLease renews the lease until ctx is cancelled, a renewal fails, or the
+// lease can't be extended any further. Returns nil if ctx was cancelled.
+func watchLease(ctx context.Context, client *vault.Client, renew TokenRenewer, leaseID string, leaseDuration int, interval func(int) time.Duration) error {
+	timer := time.NewTimer(interval(leaseDuration))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		renewed, err := renew.Renew(client, leaseID, leaseDuration)
+		if err != nil {
+			return err
+		}
+		if renewed == nil || renewed.LeaseDuration <= 0 {
+			return ErrLeaseNotExtended
+		}
+		leaseDuration = renewed.LeaseDuration
+		timer.Reset(interval(leaseDuration))
+	}
+}
+
+// LeaseCount returns the number of active leases whose IDs start with the
+// prefix, e.g. "pki/issue/" for the certs issued by a pki mount. The leases are
+// counted by listing sys/leases/lookup/<prefix> recursively, which works on
+// every edition of Vault, unlike sys/leases/count, which only reports
+// irrevocable leases. A prefix with no leases under it has a count of 0. If the
+// LogicalLister is also a PathPrefixer, the prefix is scoped by its path
+// prefix, since lease IDs start with the mount path as it's sent to Vault.
+//
+// Listing sys/leases/lookup requires sudo capability on the path, and the
+// recursive listing makes one request for every folder under the prefix, so
+// counting a busy mount can take a while.
+func LeaseCount(l LogicalLister, prefix string) (int, error) {
+	prefix = strings.Trim(prefixedPath(l, strings.Trim(prefix, "/")), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return countLeases(l, "sys/leases/lookup/"+prefix)
+}
+
+// countLeases counts the leases under the lookup path, descending into folders.
+func countLeases(l LogicalLister, path string) (int, error) {
+	keys, err := ListPath(l, path)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			count++
+			continue
+		}
+		n, err := countLeases(l, path+key)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
@@ -0,0 +1,419 @@
+package vaulter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestLeaseExpiry(t *testing.T) {
+	issuedAt := time.Now().Add(-10 * time.Minute)
+	secret := &vault.Secret{
+		LeaseID:       "pki/issue/foo/1234",
+		LeaseDuration: 3600,
+	}
+	expiry, remaining, err := LeaseExpiry(secret, issuedAt)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := issuedAt.Add(time.Hour)
+	if !expiry.Equal(expected) {
+		t.Errorf("expiry was %s instead of %s", expiry, expected)
+	}
+	if remaining > 50*time.Minute || remaining < 49*time.Minute {
+		t.Errorf("remaining was %s instead of roughly 50m", remaining)
+	}
+
+	_, remaining, err = LeaseExpiry(secret, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	if remaining >= 0 {
+		t.Errorf("remaining was %s for an expired lease", remaining)
+	}
+
+	if _, _, err = LeaseExpiry(&vault.Secret{}, issuedAt); err == nil {
+		t.Error("err was nil for a secret without a lease")
+	}
+	if _, _, err = LeaseExpiry(nil, issuedAt); err == nil {
+		t.Error("err was nil for a nil secret")
+	}
+}
+
+type StubConfigReader struct {
+	StubMountReaderWriter
+	data map[string]interface{}
+}
+
+func (s *StubConfigReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	if s.readError {
+		return nil, errors.New("read error")
+	}
+	s.path = path
+	return &vault.Secret{Data: s.data}, nil
+}
+
+func TestSystemMaxLeaseTTL(t *testing.T) {
+	r := &StubConfigReader{
+		data: map[string]interface{}{
+			"cache_size":        json.Number("0"),
+			"default_lease_ttl": json.Number("86400"),
+			"disable_mlock":     true,
+			"max_lease_ttl":     json.Number("31536000"),
+		},
+	}
+	ttl, err := SystemMaxLeaseTTL(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if r.path != "sys/config/state/sanitized" {
+		t.Errorf("path was '%s' instead of 'sys/config/state/sanitized'", r.path)
+	}
+	if ttl != 8760*time.Hour {
+		t.Errorf("ttl was %s instead of 8760h", ttl)
+	}
+
+	r.data["max_lease_ttl"] = json.Number("0")
+	ttl, err = SystemMaxLeaseTTL(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if ttl != 768*time.Hour {
+		t.Errorf("ttl was %s instead of the default 768h", ttl)
+	}
+
+	r.data["max_lease_ttl"] = "bogus"
+	if _, err = SystemMaxLeaseTTL(r); err == nil {
+		t.Error("err was nil for a non-numeric max_lease_ttl")
+	}
+
+	r = &StubConfigReader{StubMountReaderWriter: StubMountReaderWriter{readError: true}}
+	if _, err = SystemMaxLeaseTTL(r); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+type StubLeaseReader struct {
+	cfg       *vault.Config
+	token     string
+	path      string
+	secret    *vault.Secret
+	readError bool
+}
+
+func (r *StubLeaseReader) GetConfig() *vault.Config {
+	return r.cfg
+}
+
+func (r *StubLeaseReader) NewClient(cfg *vault.Config) (*vault.Client, error) {
+	return &vault.Client{}, nil
+}
+
+func (r *StubLeaseReader) SetToken(client *vault.Client, token string) {
+	r.token = token
+}
+
+func (r *StubLeaseReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	r.path = path
+	if r.readError {
+		return nil, errors.New("read error")
+	}
+	return r.secret, nil
+}
+
+type StubRenewer struct {
+	mu         sync.Mutex
+	leaseIDs   []string
+	increments []int
+	renewed    chan struct{}
+	renewError bool
+	noExtend   bool
+}
+
+func (r *StubRenewer) Renew(client *vault.Client, leaseID string, increment int) (*vault.Secret, error) {
+	r.mu.Lock()
+	r.leaseIDs = append(r.leaseIDs, leaseID)
+	r.increments = append(r.increments, increment)
+	r.mu.Unlock()
+	defer func() { r.renewed <- struct{}{} }()
+	if r.renewError {
+		return nil, errors.New("renew error")
+	}
+	if r.noExtend {
+		return &vault.Secret{LeaseID: leaseID, Renewable: true}, nil
+	}
+	return &vault.Secret{LeaseID: leaseID, LeaseDuration: 60, Renewable: true}, nil
+}
+
+func (r *StubRenewer) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.leaseIDs)
+}
+
+func fastRenewals(t *testing.T) {
+	orig := renewInterval
+	renewInterval = func(int) time.Duration { return time.Millisecond }
+	t.Cleanup(func() { renewInterval = orig })
+}
+
+func TestReadAndWatch(t *testing.T) {
+	fastRenewals(t)
+	cr := &StubLeaseReader{
+		secret: &vault.Secret{
+			LeaseID:       "database/creds/app/abc",
+			LeaseDuration: 30,
+			Renewable:     true,
+			Data:          map[string]interface{}{"username": "app"},
+		},
+	}
+	renewer := &StubRenewer{renewed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	secret, done, err := ReadAndWatch(ctx, cr, "database/creds/app", "token", renewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["username"] != "app" {
+		t.Errorf("username was %v instead of app", secret.Data["username"])
+	}
+	if cr.path != "database/creds/app" {
+		t.Errorf("path was '%s' instead of 'database/creds/app'", cr.path)
+	}
+	if cr.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", cr.token)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-renewer.renewed:
+		case <-time.After(time.Second):
+			t.Fatal("the lease was not renewed")
+		}
+	}
+	renewer.mu.Lock()
+	if renewer.leaseIDs[0] != "database/creds/app/abc" {
+		t.Errorf("lease id was '%s' instead of 'database/creds/app/abc'", renewer.leaseIDs[0])
+	}
+	if renewer.increments[0] != 30 || renewer.increments[1] != 60 {
+		t.Errorf("increments were %v instead of [30 60]", renewer.increments)
+	}
+	renewer.mu.Unlock()
+
+	cancel()
+	// Drain a renewal that may have started before the cancel was seen.
+	select {
+	case <-renewer.renewed:
+	case <-time.After(20 * time.Millisecond):
+	}
+	select {
+	case err, ok := <-done:
+		if ok {
+			t.Errorf("%v was reported after the context was cancelled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the channel wasn't closed after the context was cancelled")
+	}
+	count := renewer.count()
+	time.Sleep(20 * time.Millisecond)
+	if renewer.count() != count {
+		t.Error("the lease was renewed after the context was cancelled")
+	}
+}
+
+func TestReadAndWatchStopsOnError(t *testing.T) {
+	fastRenewals(t)
+	cr := &StubLeaseReader{
+		secret: &vault.Secret{LeaseID: "lease", LeaseDuration: 30, Renewable: true},
+	}
+	renewer := &StubRenewer{renewed: make(chan struct{}, 2), renewError: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, done, err := ReadAndWatch(ctx, cr, "database/creds/app", "token", renewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err == nil || err.Error() != "renew error" {
+			t.Errorf("err was %v instead of the renew error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the renewal failure was not reported")
+	}
+	if _, ok := <-done; ok {
+		t.Error("the channel wasn't closed after the renewal failure")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if renewer.count() != 1 {
+		t.Errorf("the lease was renewed %d times instead of once", renewer.count())
+	}
+}
+
+func TestReadAndWatchNotExtended(t *testing.T) {
+	fastRenewals(t)
+	cr := &StubLeaseReader{
+		secret: &vault.Secret{LeaseID: "lease", LeaseDuration: 30, Renewable: true},
+	}
+	renewer := &StubRenewer{renewed: make(chan struct{}, 2), noExtend: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, done, err := ReadAndWatch(ctx, cr, "database/creds/app", "token", renewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != ErrLeaseNotExtended {
+			t.Errorf("err was %v instead of %v", err, ErrLeaseNotExtended)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the lease not being extended was not reported")
+	}
+	if renewer.count() != 1 {
+		t.Errorf("the lease was renewed %d times instead of once", renewer.count())
+	}
+}
+
+func TestReadAndWatchNotRenewable(t *testing.T) {
+	fastRenewals(t)
+	cr := &StubLeaseReader{
+		secret: &vault.Secret{LeaseID: "lease", LeaseDuration: 30},
+	}
+	renewer := &StubRenewer{renewed: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, done, err := ReadAndWatch(ctx, cr, "secret/foo", "token", renewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err, ok := <-done:
+		if ok {
+			t.Errorf("%v was reported for a lease that isn't renewable", err)
+		}
+	default:
+		t.Error("the channel wasn't closed for a lease that isn't renewable")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if renewer.count() != 0 {
+		t.Error("a lease that isn't renewable was renewed")
+	}
+
+	cr = &StubLeaseReader{readError: true}
+	if _, _, err = ReadAndWatch(ctx, cr, "secret/foo", "token", renewer); err == nil {
+		t.Error("err was nil for a read error")
+	}
+	cr = &StubLeaseReader{}
+	if _, _, err = ReadAndWatch(ctx, cr, "secret/foo", "token", renewer); err == nil {
+		t.Error("err was nil for a missing secret")
+	}
+}
+
+type StubLeaseLister struct {
+	leases    map[string][]interface{}
+	paths     []string
+	listError bool
+}
+
+func (s *StubLeaseLister) Client() *vault.Client {
+	return &vault.Client{}
+}
+
+func (s *StubLeaseLister) List(client *vault.Client, path string) (*vault.Secret, error) {
+	s.paths = append(s.paths, path)
+	if s.listError {
+		return nil, errors.New("list error")
+	}
+	keys, ok := s.leases[path]
+	if !ok {
+		return nil, nil
+	}
+	return &vault.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func TestLeaseCount(t *testing.T) {
+	l := &StubLeaseLister{
+		leases: map[string][]interface{}{
+			"sys/leases/lookup/pki/":                   {"issue/"},
+			"sys/leases/lookup/pki/issue/":             {"htcondor/", "irods/"},
+			"sys/leases/lookup/pki/issue/htcondor/":    {"lease1", "lease2", "lease3"},
+			"sys/leases/lookup/pki/issue/irods/":       {"lease4"},
+			"sys/leases/lookup/database/creds/app/abc": {"ignored"},
+		},
+	}
+	count, err := LeaseCount(l, "pki/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("count was %d instead of 4", count)
+	}
+	if l.paths[0] != "sys/leases/lookup/pki/" {
+		t.Errorf("the first path was '%s' instead of 'sys/leases/lookup/pki/'", l.paths[0])
+	}
+
+	if count, err = LeaseCount(l, "pki/issue/irods"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("count was %d instead of 1", count)
+	}
+
+	if count, err = LeaseCount(l, "transit"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("count was %d instead of 0 for a prefix without leases", count)
+	}
+
+	l = &StubLeaseLister{listError: true}
+	if _, err = LeaseCount(l, "pki"); err == nil {
+		t.Error("err was nil for a list error")
+	}
+}
+
+// StubPrefixedLeaseLister scopes paths with the envA prefix, like a VaultAPI
+// with a path prefix set.
+type StubPrefixedLeaseLister struct {
+	StubLeaseLister
+}
+
+func (s *StubPrefixedLeaseLister) PrefixedPath(path string) string {
+	return "envA/" + path
+}
+
+func TestLeaseCountPrefixed(t *testing.T) {
+	l := &StubPrefixedLeaseLister{
+		StubLeaseLister{
+			leases: map[string][]interface{}{
+				"sys/leases/lookup/envA/":                 {"pki/"},
+				"sys/leases/lookup/envA/pki/":             {"issue/"},
+				"sys/leases/lookup/envA/pki/issue/":       {"irods/"},
+				"sys/leases/lookup/envA/pki/issue/irods/": {"lease1", "lease2"},
+				"sys/leases/lookup/pki/issue/irods/":      {"other-env-lease"},
+			},
+		},
+	}
+	count, err := LeaseCount(l, "pki/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count was %d instead of 2", count)
+	}
+	if l.paths[0] != "sys/leases/lookup/envA/pki/" {
+		t.Errorf("the first path was '%s' instead of 'sys/leases/lookup/envA/pki/'", l.paths[0])
+	}
+
+	if count, err = LeaseCount(l, ""); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count was %d instead of 2 for the whole prefix", count)
+	}
+}
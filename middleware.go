@@ -0,0 +1,33 @@
+package vaulter
+
+import "context"
+
+// Operation describes a single call made to Vault by a VaultAPI.
+type Operation struct {
+	Name    string          // The name of the operation, e.g. "read", "write", or "mount".
+	Path    string          // The path the operation acts on, before any path prefix is applied.
+	Context context.Context // The context for the call. Nil if the operation doesn't take one.
+}
+
+// OpFunc performs an operation against Vault.
+type OpFunc func(op *Operation) error
+
+// Middleware wraps an OpFunc with cross-cutting behavior such as logging,
+// metrics, or retries. A Middleware may short-circuit the operation by
+// returning without calling next.
+type Middleware func(next OpFunc) OpFunc
+
+// Use adds middleware to the chain that's run around each operation. The
+// middleware added first is the outermost, so it runs first and sees the
+// final result.
+func (v *VaultAPI) Use(mw ...Middleware) {
+	v.middleware = append(v.middleware, mw...)
+}
+
+// run performs the operation through the middleware chain.
+func (v *VaultAPI) run(op *Operation, fn OpFunc) error {
+	for i := len(v.middleware) - 1; i >= 0; i-- {
+		fn = v.middleware[i](fn)
+	}
+	return fn(op)
+}
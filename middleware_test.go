@@ -0,0 +1,158 @@
+package vaulter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	rec := &requestRecorder{}
+	client := newTestClient(t, rec.handler)
+	api := &VaultAPI{}
+	api.SetClient(client)
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next OpFunc) OpFunc {
+			return func(op *Operation) error {
+				calls = append(calls, name+" before "+op.Name+" "+op.Path)
+				err := next(op)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+	api.Use(record("first"), record("second"))
+
+	if _, err := api.Read(client, "pki/roles/foo"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"first before read pki/roles/foo",
+		"second before read pki/roles/foo",
+		"second after",
+		"first after",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("calls were %v instead of %v", calls, expected)
+	}
+	if rec.last() != "GET /v1/pki/roles/foo" {
+		t.Errorf("last request was '%s' instead of 'GET /v1/pki/roles/foo'", rec.last())
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	rec := &requestRecorder{}
+	client := newTestClient(t, rec.handler)
+	api := &VaultAPI{}
+	api.SetClient(client)
+
+	denied := errors.New("denied")
+	var reached bool
+	api.Use(
+		func(next OpFunc) OpFunc {
+			return func(op *Operation) error {
+				if op.Name == "write" {
+					return denied
+				}
+				return next(op)
+			}
+		},
+		func(next OpFunc) OpFunc {
+			return func(op *Operation) error {
+				reached = true
+				return next(op)
+			}
+		},
+	)
+
+	if _, err := api.Write(client, "pki/issue/foo", map[string]interface{}{}); err != denied {
+		t.Errorf("err was %v instead of %v", err, denied)
+	}
+	if reached {
+		t.Error("the inner middleware ran after the chain was short-circuited")
+	}
+	if rec.last() != "" {
+		t.Errorf("a request was made after the chain was short-circuited: %s", rec.last())
+	}
+
+	if _, err := api.Read(client, "pki/roles/foo"); err != nil {
+		t.Error(err)
+	}
+	if !reached {
+		t.Error("the inner middleware didn't run for a read")
+	}
+}
+
+func TestMiddlewareRewritesPath(t *testing.T) {
+	rec := &requestRecorder{}
+	client := newTestClient(t, rec.handler)
+	api := &VaultAPI{}
+	api.SetClient(client)
+	api.SetPathPrefix("envA")
+	api.Use(func(next OpFunc) OpFunc {
+		return func(op *Operation) error {
+			op.Path = "other/" + op.Path
+			return next(op)
+		}
+	})
+
+	if _, err := api.Delete(client, "roles/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if rec.last() != "DELETE /v1/envA/other/roles/foo" {
+		t.Errorf("last request was '%s' instead of 'DELETE /v1/envA/other/roles/foo'", rec.last())
+	}
+}
+
+func TestMiddlewareNamedOperations(t *testing.T) {
+	rec := &requestRecorder{}
+	client := newTestClient(t, rec.handler)
+	api := &VaultAPI{}
+	api.SetClient(client)
+
+	var ops []string
+	api.Use(func(next OpFunc) OpFunc {
+		return func(op *Operation) error {
+			ops = append(ops, op.Name+" "+op.Path)
+			return next(op)
+		}
+	})
+
+	calls := []func(){
+		func() { api.CreateToken(api.Token(), &vault.TokenCreateRequest{}) },
+		func() { api.LookupSelf() },
+		func() { api.Lookup("secret-token") },
+		func() { api.RevokeToken("secret-token") },
+		func() { api.GetPolicy("jobs") },
+		func() { api.Health() },
+		func() { api.SealStatus() },
+		func() { api.Renew(client, "pki/issue/foo/1234", 60) },
+		func() { api.LoginAppRole("role-id", "secret-id") },
+		func() { api.LoginUserpass("ipcdev", "password") },
+		func() { api.LoginKubernetes("jobs", "jwt") },
+	}
+	for _, call := range calls {
+		call()
+	}
+
+	expected := []string{
+		"create-token auth/token/create",
+		"lookup-self auth/token/lookup-self",
+		"lookup-token auth/token/lookup",
+		"revoke-token auth/token/revoke",
+		"get-policy sys/policy/jobs",
+		"health sys/health",
+		"seal-status sys/seal-status",
+		"renew pki/issue/foo/1234",
+		"login auth/approle/login",
+		"login auth/userpass/login/ipcdev",
+		"login auth/kubernetes/login",
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("operations were %v instead of %v", ops, expected)
+	}
+}
@@ -0,0 +1,125 @@
+package vaulter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// CachingMountLister is a MountLister that wraps another MountLister and caches
+// the results of ListMounts for TTL. Calls made while a ListMounts call is
+// already in flight wait for it and share its result instead of making their
+// own. ListMounts calls that fail with transient errors, as described for
+// WithRetry, are retried up to Retries times, waiting RetryWait between
+// attempts. The map returned by ListMounts is shared between callers and must
+// not be modified.
+type CachingMountLister struct {
+	Lister    MountLister
+	TTL       time.Duration
+	Retries   int
+	RetryWait time.Duration
+
+	mu         sync.Mutex
+	mounts     map[string]*vault.MountOutput
+	fetched    time.Time
+	call       *listMountsCall
+	generation uint64 // Incremented by Invalidate so older calls don't fill the cache.
+}
+
+// listMountsCall tracks a ListMounts call that is in flight.
+type listMountsCall struct {
+	done   chan struct{}
+	mounts map[string]*vault.MountOutput
+	err    error
+}
+
+// NewCachingMountLister returns a *CachingMountLister that caches the results
+// from the provided MountLister for the given duration.
+func NewCachingMountLister(l MountLister, ttl time.Duration) *CachingMountLister {
+	return &CachingMountLister{
+		Lister: l,
+		TTL:    ttl,
+	}
+}
+
+// ListMounts returns the cached list of mounted Vault backends, calling the
+// underlying MountLister if the cache is empty or has expired.
+func (c *CachingMountLister) ListMounts() (map[string]*vault.MountOutput, error) {
+	return c.ListMountsWithContext(context.Background())
+}
+
+// ListMountsWithContext returns the cached list of mounted Vault backends the
+// same way as ListMounts, giving up when the context is done, including while
+// waiting on another caller's call or between retries. Callers waiting on a
+// call get its result, even if it was cut short by the context of the caller
+// that made it.
+func (c *CachingMountLister) ListMountsWithContext(ctx context.Context) (map[string]*vault.MountOutput, error) {
+	c.mu.Lock()
+	if c.mounts != nil && time.Since(c.fetched) < c.TTL {
+		mounts := c.mounts
+		c.mu.Unlock()
+		return mounts, nil
+	}
+	if call := c.call; call != nil {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.mounts, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &listMountsCall{done: make(chan struct{})}
+	c.call = call
+	generation := c.generation
+	c.mu.Unlock()
+
+	call.mounts, call.err = c.listMounts(ctx)
+
+	c.mu.Lock()
+	if call.err == nil && c.generation == generation {
+		c.mounts = call.mounts
+		c.fetched = time.Now()
+	}
+	if c.call == call {
+		c.call = nil
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.mounts, call.err
+}
+
+// listMounts calls ListMounts on the underlying MountLister, retrying
+// transient failures.
+func (c *CachingMountLister) listMounts(ctx context.Context) (map[string]*vault.MountOutput, error) {
+	var mounts map[string]*vault.MountOutput
+	cfg := RetryConfig{
+		MaxAttempts: c.Retries + 1,
+		BaseDelay:   c.RetryWait,
+		MaxDelay:    c.RetryWait,
+		Jitter:      NoJitter,
+	}
+	err := withRetry(ctx, cfg, func() (err error) {
+		mounts, err = c.Lister.ListMounts()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// Invalidate clears the cached list of mounts. Should be called after mounting
+// or unmounting a backend. A ListMounts call that's in flight isn't cached when
+// it finishes, since it may have missed the change, and later calls don't wait
+// for it.
+func (c *CachingMountLister) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mounts = nil
+	c.call = nil
+	c.generation++
+}
@@ -0,0 +1,215 @@
+package vaulter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubCountingMountLister struct {
+	calls      int32
+	failures   int32
+	failStatus int // The status of the failed responses. 503 if 0.
+	release    chan struct{}
+}
+
+func (s *StubCountingMountLister) ListMounts() (map[string]*vault.MountOutput, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	if n <= s.failures {
+		status := s.failStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		return nil, &vault.ResponseError{StatusCode: status}
+	}
+	return map[string]*vault.MountOutput{
+		"cubbyhole/": &vault.MountOutput{},
+		"pki/":       &vault.MountOutput{},
+	}, nil
+}
+
+func TestCachingMountListerConcurrent(t *testing.T) {
+	stub := &StubCountingMountLister{release: make(chan struct{})}
+	lister := NewCachingMountLister(stub, time.Minute)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := IsMounted(lister, "pki")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !m {
+				errs <- errors.New("the pki backend was not found")
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stub.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 1 {
+		t.Errorf("ListMounts was called %d times instead of 1", calls)
+	}
+
+	lister.Invalidate()
+	if _, err := IsMounted(lister, "pki"); err != nil {
+		t.Error(err)
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 2 {
+		t.Errorf("ListMounts was called %d times instead of 2 after Invalidate()", calls)
+	}
+}
+
+func TestCachingMountListerExpiry(t *testing.T) {
+	stub := &StubCountingMountLister{}
+	lister := NewCachingMountLister(stub, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := lister.ListMounts(); err != nil {
+			t.Error(err)
+		}
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 3 {
+		t.Errorf("ListMounts was called %d times instead of 3", calls)
+	}
+}
+
+func TestCachingMountListerRetry(t *testing.T) {
+	stub := &StubCountingMountLister{failures: 2}
+	lister := NewCachingMountLister(stub, time.Minute)
+	lister.Retries = 2
+	m, err := IsMounted(lister, "pki")
+	if err != nil {
+		t.Error(err)
+	}
+	if !m {
+		t.Error("the pki backend was not found")
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 3 {
+		t.Errorf("ListMounts was called %d times instead of 3", calls)
+	}
+
+	stub = &StubCountingMountLister{failures: 3}
+	lister = NewCachingMountLister(stub, time.Minute)
+	lister.Retries = 2
+	if _, err = IsMounted(lister, "pki"); err == nil {
+		t.Error("err was nil")
+	}
+	if _, err = IsMounted(lister, "pki"); err != nil {
+		t.Errorf("failed calls were cached: %s", err)
+	}
+}
+
+func TestCachingMountListerRetryNotTransient(t *testing.T) {
+	stub := &StubCountingMountLister{failures: 1, failStatus: http.StatusForbidden}
+	lister := NewCachingMountLister(stub, time.Minute)
+	lister.Retries = 2
+	if _, err := lister.ListMounts(); err == nil {
+		t.Error("err was nil for a forbidden response")
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 1 {
+		t.Errorf("ListMounts was called %d times instead of once", calls)
+	}
+}
+
+func TestCachingMountListerCancelledDuringRetry(t *testing.T) {
+	stub := &StubCountingMountLister{failures: 5}
+	lister := NewCachingMountLister(stub, time.Minute)
+	lister.Retries = 2
+	lister.RetryWait = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := lister.ListMountsWithContext(ctx)
+		done <- err
+	}()
+	waitForCalls(t, stub, 1)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("err was nil after the context was cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListMountsWithContext didn't return when the context was cancelled")
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 1 {
+		t.Errorf("ListMounts was called %d times instead of once", calls)
+	}
+}
+
+func TestCachingMountListerInvalidateInFlight(t *testing.T) {
+	stub := &StubCountingMountLister{release: make(chan struct{})}
+	lister := NewCachingMountLister(stub, time.Minute)
+
+	var wg sync.WaitGroup
+	list := func() {
+		defer wg.Done()
+		if _, err := lister.ListMounts(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Add(1)
+	go list()
+	waitForCalls(t, stub, 1)
+	lister.Invalidate()
+
+	// A call made after Invalidate doesn't wait on the stale call.
+	wg.Add(1)
+	go list()
+	waitForCalls(t, stub, 2)
+	close(stub.release)
+	wg.Wait()
+
+	// The call made after Invalidate is cached.
+	if _, err := lister.ListMounts(); err != nil {
+		t.Error(err)
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 2 {
+		t.Errorf("ListMounts was called %d times instead of 2", calls)
+	}
+
+	// The stale call isn't.
+	stub = &StubCountingMountLister{release: make(chan struct{})}
+	lister = NewCachingMountLister(stub, time.Minute)
+	wg.Add(1)
+	go list()
+	waitForCalls(t, stub, 1)
+	lister.Invalidate()
+	close(stub.release)
+	wg.Wait()
+	if _, err := lister.ListMounts(); err != nil {
+		t.Error(err)
+	}
+	if calls := atomic.LoadInt32(&stub.calls); calls != 2 {
+		t.Errorf("ListMounts was called %d times instead of 2 after the stale call finished", calls)
+	}
+}
+
+// waitForCalls waits until ListMounts has been called n times on the stub.
+func waitForCalls(t *testing.T, stub *StubCountingMountLister, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&stub.calls) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("ListMounts was called %d times instead of %d", atomic.LoadInt32(&stub.calls), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
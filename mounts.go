@@ -1,8 +1,17 @@
 package vaulter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
@@ -41,18 +50,54 @@ type MountReader interface {
 	Read(c *vault.Client, path string) (*vault.Secret, error)
 }
 
+// MountDataReader is an interface for objects that can read data from a path
+// in a Vault backend with query parameters.
+type MountDataReader interface {
+	ReadWithData(c *vault.Client, path string, data map[string][]string) (*vault.Secret, error)
+}
+
+// MountContextWriter is an interface for objects that can write to a path in a
+// Vault backend, giving up when the context is done.
+type MountContextWriter interface {
+	WriteWithContext(ctx context.Context, c *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error)
+}
+
+// MountContextReader is an interface for objects that can read data from a path
+// in a Vault backend, giving up when the context is done.
+type MountContextReader interface {
+	ReadWithContext(ctx context.Context, c *vault.Client, path string) (*vault.Secret, error)
+}
+
 // PathDeleter is an interface for deleting information from a mount, not for
 // deleting the mount itself.
 type PathDeleter interface {
 	Delete(c *vault.Client, path string) (*vault.Secret, error)
 }
 
+// MountDefaulter is an interface for objects that provide default settings for
+// new mounts.
+type MountDefaulter interface {
+	DefaultMountConfig() *MountConfiguration
+}
+
+// PathLister is an interface for objects that can list the keys stored under a
+// path in a Vault backend.
+type PathLister interface {
+	List(c *vault.Client, path string) (*vault.Secret, error)
+}
+
 // Unmounter is an interface for objects that can unmount a Vault
 // backend.
 type Unmounter interface {
 	Unmount(path string) error
 }
 
+// Remounter is an interface for objects that can move a Vault backend to a new
+// path.
+type Remounter interface {
+	Remount(from, to string) error
+}
+
 // MountReaderWriter defines an interface for doing role related operations.
 type MountReaderWriter interface {
 	ClientGetter
@@ -60,6 +105,28 @@ type MountReaderWriter interface {
 	MountReader
 }
 
+// KV2Reader defines the interface for reading versioned secrets from a KV
+// version 2 backend.
+type KV2Reader interface {
+	ClientGetter
+	MountReader
+	MountDataReader
+}
+
+// LogicalLister defines an interface for listing the keys stored under a path
+// using the object's own client.
+type LogicalLister interface {
+	ClientGetter
+	PathLister
+}
+
+// LogicalWriter defines an interface for writing data to a path using the
+// object's own client.
+type LogicalWriter interface {
+	ClientGetter
+	MountWriter
+}
+
 // MountDeleter defines and interface for deleting content from a path in a
 // mounted backend.
 type MountDeleter interface {
@@ -67,6 +134,14 @@ type MountDeleter interface {
 	PathDeleter
 }
 
+// MountInfoReader defines the interface for reading the information for a
+// single mount.
+type MountInfoReader interface {
+	ClientGetter
+	MountReader
+	MountLister
+}
+
 // MountConfiguration is a flattened representation of the configs that the Vault API
 // supports for the backend mounts.
 type MountConfiguration struct {
@@ -76,8 +151,192 @@ type MountConfiguration struct {
 	MaxLeaseTTL     string
 }
 
-// Mount mounts a vault backend with the provided
+// TTLToSeconds converts a TTL string in the format used by MountConfiguration
+// into the number of seconds that Vault reports in a *vault.MountConfigOutput.
+// Accepts a bare number of seconds ("86400"), a Go duration ("24h"), or a
+// number of days ("30d"). An empty TTL converts to 0, which Vault treats as
+// "use the system default".
+func TTLToSeconds(ttl string) (int, error) {
+	ttl = strings.TrimSpace(ttl)
+	if ttl == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(ttl); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("negative TTL %s", ttl)
+		}
+		return secs, nil
+	}
+	if strings.HasSuffix(ttl, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(ttl, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid TTL %s", ttl)
+		}
+		return days * 24 * 60 * 60, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TTL %s: %s", ttl, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative TTL %s", ttl)
+	}
+	return int(d / time.Second), nil
+}
+
+// SecondsToTTL converts a number of seconds as reported in a
+// *vault.MountConfigOutput into a TTL string usable in a MountConfiguration.
+// 0 converts to an empty string, meaning "use the system default". The TTL is
+// in Go's duration format, e.g. 86400 converts to "24h0m0s", so it won't
+// match a hand-written "24h" or "1d" as a string. Use TTLEqual to compare
+// TTLs.
+func SecondsToTTL(secs int) string {
+	if secs <= 0 {
+		return ""
+	}
+	return (time.Duration(secs) * time.Second).String()
+}
+
+// TTLEqual returns true if the two TTLs are the same length of time, no matter
+// which of the formats accepted by TTLToSeconds they're written in. For
+// example, "24h", "1d", "86400", and "24h0m0s" are all equal. Returns an error
+// if either TTL can't be parsed.
+func TTLEqual(a, b string) (bool, error) {
+	aSecs, err := TTLToSeconds(a)
+	if err != nil {
+		return false, err
+	}
+	bSecs, err := TTLToSeconds(b)
+	if err != nil {
+		return false, err
+	}
+	return aSecs == bSecs, nil
+}
+
+// ParseMountConfig converts the TTLs in a *vault.MountConfigOutput into a
+// *MountConfiguration so that they can be compared against the desired
+// settings for a mount with TTLEqual. The Type and Description fields are not
+// part of a MountConfigOutput and are left empty. Returns nil if o is nil.
+func ParseMountConfig(o *vault.MountConfigOutput) *MountConfiguration {
+	if o == nil {
+		return nil
+	}
+	return &MountConfiguration{
+		DefaultLeaseTTL: SecondsToTTL(o.DefaultLeaseTTL),
+		MaxLeaseTTL:     SecondsToTTL(o.MaxLeaseTTL),
+	}
+}
+
+// MountConfigTuner defines the interface for reading a mount's config and
+// tuning it.
+type MountConfigTuner interface {
+	MountConfigGetter
+	MountTuner
+}
+
+// EnsureTuned reads the config of the mount at path and tunes it if its TTLs
+// don't match the desired ones, comparing them with TTLEqual so that "24h" and
+// 86400 seconds count as the same. Empty TTLs in desired are left as they are.
+// The Type and Description fields aren't checked. Returns true if the mount
+// was tuned.
+func EnsureTuned(t MountConfigTuner, path string, desired *MountConfiguration) (bool, error) {
+	out, err := MountConfig(t, path)
+	if err != nil {
+		return false, err
+	}
+	current := ParseMountConfig(out)
+	if current == nil {
+		return false, fmt.Errorf("no config was returned for %s", path)
+	}
+	var (
+		in      vault.MountConfigInput
+		changed bool
+	)
+	ttls := []struct {
+		current, desired string
+		field            *string
+	}{
+		{current.DefaultLeaseTTL, desired.DefaultLeaseTTL, &in.DefaultLeaseTTL},
+		{current.MaxLeaseTTL, desired.MaxLeaseTTL, &in.MaxLeaseTTL},
+	}
+	for _, ttl := range ttls {
+		if ttl.desired == "" {
+			continue
+		}
+		equal, err := TTLEqual(ttl.current, ttl.desired)
+		if err != nil {
+			return false, err
+		}
+		if !equal {
+			*ttl.field = ttl.desired
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if err = t.TuneMount(path, in); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReconcileMounts calls EnsureTuned for each of the desired mounts, in order of
+// their paths, then waits for the interval and does it again until ctx is
+// cancelled. The result for each mount is passed to report, which may be nil.
+// A failure for one mount doesn't stop the others from being checked.
+func ReconcileMounts(ctx context.Context, t MountConfigTuner, desired map[string]MountConfiguration, interval time.Duration, report func(path string, changed bool, err error)) {
+	paths := make([]string, 0, len(desired))
+	for path := range desired {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for {
+		for _, path := range paths {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg := desired[path]
+			changed, err := EnsureTuned(t, path, &cfg)
+			if report != nil {
+				report(path, changed, err)
+			}
+		}
+		if sleep(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+// withDefaults returns a copy of the MountConfiguration with its empty fields
+// filled in from the provided defaults.
+func (c *MountConfiguration) withDefaults(d *MountConfiguration) *MountConfiguration {
+	merged := *c
+	if d == nil {
+		return &merged
+	}
+	if merged.Type == "" {
+		merged.Type = d.Type
+	}
+	if merged.Description == "" {
+		merged.Description = d.Description
+	}
+	if merged.DefaultLeaseTTL == "" {
+		merged.DefaultLeaseTTL = d.DefaultLeaseTTL
+	}
+	if merged.MaxLeaseTTL == "" {
+		merged.MaxLeaseTTL = d.MaxLeaseTTL
+	}
+	return &merged
+}
+
+// Mount mounts a vault backend with the provided path and configuration. If
+// the Mounter is also a MountDefaulter, empty fields in the configuration are
+// filled in from its default mount configuration.
 func Mount(m Mounter, path string, c *MountConfiguration) error {
+	if md, ok := m.(MountDefaulter); ok {
+		c = c.withDefaults(md.DefaultMountConfig())
+	}
 	return m.Mount(path, &vault.MountInput{
 		Type:        c.Type,
 		Description: c.Description,
@@ -93,69 +352,314 @@ func Unmount(u Unmounter, path string) error {
 	return u.Unmount(path)
 }
 
+// Remount moves the backend mounted at the from path to the to path, keeping
+// its data. Newer versions of Vault perform the move asynchronously; the
+// VaultAPI implementation waits for the migration to finish by polling its
+// status, which can take a while for large mounts, and fails if the migration
+// fails or doesn't finish within its remount timeout. Other implementations
+// may return before the move is complete. Any error is returned as is.
+func Remount(r Remounter, from, to string) error {
+	return r.Remount(from, to)
+}
+
 // MountConfig returns the config for the passed in mount rooted at the given
 // path.
 func MountConfig(m MountConfigGetter, path string) (*vault.MountConfigOutput, error) {
 	return m.MountConfig(path)
 }
 
+// findMount returns the *vault.MountOutput for the backend mounted at the given
+// path, or nil if nothing is mounted there.
+func findMount(l MountLister, path string) (*vault.MountOutput, error) {
+	mounts, err := l.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	for m, mo := range mounts {
+		if strings.TrimSuffix(m, "/") == strings.TrimSuffix(path, "/") {
+			if mo == nil {
+				mo = &vault.MountOutput{}
+			}
+			return mo, nil
+		}
+	}
+	return nil, nil
+}
+
 // IsMounted returns true if the given path is mounted as a backend in Vault.
 func IsMounted(l MountLister, path string) (bool, error) {
-	var (
-		hasPath bool
-		err     error
-	)
-	mounts, err := l.ListMounts()
+	mo, err := findMount(l, path)
+	if err != nil {
+		return false, err
+	}
+	return mo != nil, nil
+}
+
+// IsMountedType returns true if the given path is mounted as a backend in Vault
+// and the backend is of the expected type, e.g. "pki" or "kv".
+func IsMountedType(l MountLister, path, expectedType string) (bool, error) {
+	mo, err := findMount(l, path)
+	if err != nil {
+		return false, err
+	}
+	if mo == nil {
+		return false, nil
+	}
+	return mo.Type == expectedType, nil
+}
+
+// ReadMountInfo returns the information for the backend mounted at the given
+// path by reading sys/mounts/<path>, which needs less privilege than listing
+// every mount. Falls back to finding the mount with ListMounts when the read
+// returns nothing or fails with a 404 or 405, which is how Vault servers that
+// don't support reading a single mount respond. Other errors, such as a 403,
+// are returned as is. Returns an error if nothing is mounted at the path.
+func ReadMountInfo(m MountInfoReader, path string) (*vault.MountOutput, error) {
+	path = strings.Trim(path, "/")
+	secret, err := m.Read(m.Client(), fmt.Sprintf("sys/mounts/%s", path))
+	if err != nil && !isUnsupportedPath(err) {
+		return nil, err
+	}
+	if err == nil && secret != nil && secret.Data != nil {
+		encoded, err := json.Marshal(secret.Data)
+		if err != nil {
+			return nil, err
+		}
+		mo := &vault.MountOutput{}
+		if err = json.Unmarshal(encoded, mo); err != nil {
+			return nil, err
+		}
+		return mo, nil
+	}
+	mo, err := findMount(m, path)
+	if err != nil {
+		return nil, err
+	}
+	if mo == nil {
+		return nil, fmt.Errorf("%s is not mounted", path)
+	}
+	return mo, nil
+}
+
+// isUnsupportedPath returns true if the error is a 404 or 405 response from
+// Vault.
+func isUnsupportedPath(err error) bool {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.StatusCode == http.StatusNotFound || respErr.StatusCode == http.StatusMethodNotAllowed
+}
+
+// MountDescriptionMatches returns true if the backend mounted at the given path
+// has the expected description. Returns an error if nothing is mounted at the
+// path.
+func MountDescriptionMatches(l MountLister, path, expected string) (bool, error) {
+	mo, err := findMount(l, path)
 	if err != nil {
 		return false, err
 	}
-	for m := range mounts {
-		if strings.TrimSuffix(m, "/") == path {
-			hasPath = true
+	if mo == nil {
+		return false, fmt.Errorf("%s is not mounted", path)
+	}
+	return mo.Description == expected, nil
+}
+
+// MountPluginVersion contains the plugin version information for a mount.
+type MountPluginVersion struct {
+	PluginVersion        string // The plugin version the mount is configured to use.
+	RunningPluginVersion string // The plugin version that is actually running.
+	RunningSha256        string // The SHA256 sum of the running plugin binary.
+}
+
+// PluginVersion returns the plugin version information for the backend mounted
+// at the given path. Returns an error if nothing is mounted at the path.
+func PluginVersion(l MountLister, path string) (*MountPluginVersion, error) {
+	mo, err := findMount(l, path)
+	if err != nil {
+		return nil, err
+	}
+	if mo == nil {
+		return nil, fmt.Errorf("%s is not mounted", path)
+	}
+	return &MountPluginVersion{
+		PluginVersion:        mo.PluginVersion,
+		RunningPluginVersion: mo.RunningVersion,
+		RunningSha256:        mo.RunningSha256,
+	}, nil
+}
+
+// MountInfo contains the commonly needed fields of a mounted backend.
+type MountInfo struct {
+	Path            string
+	Type            string
+	Accessor        string
+	Description     string
+	Options         map[string]string
+	Local           bool
+	SealWrap        bool
+	PluginVersion   string
+	DefaultLeaseTTL string // Empty if the system default is used.
+	MaxLeaseTTL     string // Empty if the system default is used.
+}
+
+// InventoryMounts returns a MountInfo for each mounted backend, sorted by path.
+func InventoryMounts(l MountLister) ([]MountInfo, error) {
+	mounts, err := l.ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	inventory := make([]MountInfo, 0, len(mounts))
+	for path, mo := range mounts {
+		if mo == nil {
+			continue
 		}
+		inventory = append(inventory, MountInfo{
+			Path:            path,
+			Type:            mo.Type,
+			Accessor:        mo.Accessor,
+			Description:     mo.Description,
+			Options:         mo.Options,
+			Local:           mo.Local,
+			SealWrap:        mo.SealWrap,
+			PluginVersion:   mo.PluginVersion,
+			DefaultLeaseTTL: SecondsToTTL(mo.Config.DefaultLeaseTTL),
+			MaxLeaseTTL:     SecondsToTTL(mo.Config.MaxLeaseTTL),
+		})
+	}
+	sort.Slice(inventory, func(i, j int) bool {
+		return inventory[i].Path < inventory[j].Path
+	})
+	return inventory, nil
+}
+
+// ReloadPlugin reloads every mount backed by the named plugin, so that an
+// upgraded plugin binary is picked up without unmounting. The scope may be
+// empty to reload on the current node only, or "global" to reload on every
+// node in the cluster.
+func ReloadPlugin(m MountReaderWriter, pluginName, scope string) error {
+	data := map[string]interface{}{
+		"plugin": pluginName,
+	}
+	if scope != "" {
+		data["scope"] = scope
 	}
-	return hasPath, nil
+	_, err := m.Write(m.Client(), "sys/plugins/reload/backend", data)
+	return err
 }
 
 // WriteMount writes data to a path in a backend using a newly created
 // client whose token is set to the one provided.
 func WriteMount(cw ClientWriter, path, token string, data map[string]interface{}) error {
-	var (
-		client *vault.Client
-		err    error
-	)
+	_, err := writeMount(cw, path, token, data)
+	return err
+}
+
+// WriteMountWithLease writes data to a path in a backend using a newly created
+// client whose token is set to the one provided, asking Vault to apply the
+// given TTL to it. The returned secret carries the resulting lease
+// information, if any.
+func WriteMountWithLease(cw ClientWriter, path, token string, data map[string]interface{}, ttl string) (*vault.Secret, error) {
+	leased := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		leased[k] = v
+	}
+	leased["ttl"] = ttl
+	return writeMount(cw, path, token, leased)
+}
+
+// writeMount writes data to a path in a backend using a newly created client
+// whose token is set to the one provided.
+func writeMount(cw ClientWriter, path, token string, data map[string]interface{}) (*vault.Secret, error) {
+	client, err := newWriteClient(cw, token)
+	if err != nil {
+		return nil, err
+	}
+	return cw.Write(client, path, data)
+}
+
+// WriteMountWithContext writes data to a path in a backend the same way as
+// WriteMount, giving up when the context is done.
+func WriteMountWithContext(ctx context.Context, cw ClientContextWriter, path, token string, data map[string]interface{}) error {
+	client, err := newWriteClient(cw, token)
+	if err != nil {
+		return err
+	}
+	_, err = cw.WriteWithContext(ctx, client, path, data)
+	return err
+}
+
+// newWriteClient creates a client for writing to a mount, with its token set to
+// the one provided. Only the address, the retry settings, and the HTTP client
+// are taken from the configured client. The HTTP client carries the TLS
+// settings, such as the CA cert and Insecure.
+func newWriteClient(cw writeClientCreator, token string) (*vault.Client, error) {
 	defcfg := cw.DefaultConfig()
 	newcfg := cw.GetConfig()
 	defcfg.Address = newcfg.Address
-	defcfg.MaxRetries = newcfg.MaxRetries
-	if client, err = cw.NewClient(defcfg); err != nil {
-		return err
+	if newcfg.HttpClient != nil {
+		defcfg.HttpClient = newcfg.HttpClient
 	}
-	cw.SetToken(client, token)
-	_, err = cw.Write(client, path, data)
+	defcfg.MaxRetries = newcfg.MaxRetries
+	defcfg.MinRetryWait = newcfg.MinRetryWait
+	defcfg.MaxRetryWait = newcfg.MaxRetryWait
+	client, err := cw.NewClient(defcfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	cw.SetToken(client, token)
+	return client, nil
 }
 
 // ReadMount reads data from a path in a mount using a newly created client
-// whose token is set to the one provided.
+// whose token is set to the one provided. If nothing is stored at the path, the
+// error is ErrSecretNotFound, which can be checked for with errors.Is.
 func ReadMount(cr ClientReader, path, token string) (map[string]interface{}, error) {
-	var (
-		client *vault.Client
-		err    error
-	)
-	if client, err = cr.NewClient(cr.GetConfig()); err != nil {
+	client, err := newReadClient(cr, token)
+	if err != nil {
 		return nil, err
 	}
-	cr.SetToken(client, token)
 	secret, err := cr.Read(client, path)
 	if err != nil {
 		return nil, err
 	}
+	return secretData(secret)
+}
+
+// ReadMountWithContext reads data from a path in a mount the same way as
+// ReadMount, giving up when the context is done.
+func ReadMountWithContext(ctx context.Context, cr ClientContextReader, path, token string) (map[string]interface{}, error) {
+	client, err := newReadClient(cr, token)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := cr.ReadWithContext(ctx, client, path)
+	if err != nil {
+		return nil, err
+	}
+	return secretData(secret)
+}
+
+// newReadClient creates a client for reading from a mount, with its token set
+// to the one provided.
+func newReadClient(cr readClientCreator, token string) (*vault.Client, error) {
+	client, err := cr.NewClient(cr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	cr.SetToken(client, token)
+	return client, nil
+}
+
+// ErrSecretNotFound is returned when nothing is stored at the path that was
+// read.
+var ErrSecretNotFound = errors.New("no secret was found at the path")
+
+// secretData returns the data from a secret that was read from a mount, or
+// ErrSecretNotFound if the secret is nil.
+func secretData(secret *vault.Secret) (map[string]interface{}, error) {
 	if secret == nil {
-		return nil, errors.New("secret is nil")
+		return nil, ErrSecretNotFound
 	}
 	if secret.Data == nil {
 		return nil, errors.New("data is nil")
@@ -163,6 +667,42 @@ func ReadMount(cr ClientReader, path, token string) (map[string]interface{}, err
 	return secret.Data, nil
 }
 
+// ReadRawSecret reads the secret at the given path using the client from the
+// ClientGetter, returning the raw JSON body of Vault's response along with the
+// parsed secret. If nothing exists at the path, the secret is nil. If the
+// ClientGetter is also a PathPrefixer, the prefixed path is read.
+func ReadRawSecret(m ClientGetter, path string) ([]byte, *vault.Secret, error) {
+	resp, err := m.Client().Logical().ReadRaw(prefixedPath(m, path))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return body, nil, nil
+	}
+	secret, err := vault.ParseSecret(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, secret, nil
+}
+
+// ListPath returns the keys stored under a path in a mount. If nothing is
+// stored under the path, an empty slice is returned.
+func ListPath(l LogicalLister, path string) ([]string, error) {
+	secret, err := l.List(l.Client(), path)
+	if err != nil {
+		return nil, err
+	}
+	return secretKeys(secret)
+}
+
 // Delete deletes data from the path in the mount. Does not delete a mount.
 // You unmount a mount, you don't delete one.
 func Delete(md MountDeleter, path string) (*vault.Secret, error) {
@@ -1,9 +1,14 @@
 package vaulter
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 )
@@ -53,6 +58,64 @@ func TestMountPKI(t *testing.T) {
 	}
 }
 
+type StubDefaultingMounter struct {
+	StubMounter
+	defaults *MountConfiguration
+}
+
+func (s *StubDefaultingMounter) DefaultMountConfig() *MountConfiguration {
+	return s.defaults
+}
+
+func TestMountDefaults(t *testing.T) {
+	sm := &StubDefaultingMounter{
+		defaults: &MountConfiguration{
+			DefaultLeaseTTL: "768h",
+			MaxLeaseTTL:     "8760h",
+		},
+	}
+	cfg := &MountConfiguration{
+		Type:        "pki",
+		Description: "A pki backend for HTCondor jobs",
+	}
+	if err := Mount(sm, "pki/", cfg); err != nil {
+		t.Error(err)
+	}
+	if sm.mi.Config.DefaultLeaseTTL != "768h" {
+		t.Errorf("default lease TTL was '%s' instead of '768h'", sm.mi.Config.DefaultLeaseTTL)
+	}
+	if sm.mi.Config.MaxLeaseTTL != "8760h" {
+		t.Errorf("max lease TTL was '%s' instead of '8760h'", sm.mi.Config.MaxLeaseTTL)
+	}
+	if sm.mi.Type != "pki" {
+		t.Errorf("type was %s instead of pki", sm.mi.Type)
+	}
+	if cfg.DefaultLeaseTTL != "" || cfg.MaxLeaseTTL != "" {
+		t.Error("the caller's MountConfiguration was modified")
+	}
+
+	if err := Mount(sm, "pki/", &MountConfiguration{
+		Type:        "pki",
+		MaxLeaseTTL: "24h",
+	}); err != nil {
+		t.Error(err)
+	}
+	if sm.mi.Config.DefaultLeaseTTL != "768h" {
+		t.Errorf("default lease TTL was '%s' instead of '768h'", sm.mi.Config.DefaultLeaseTTL)
+	}
+	if sm.mi.Config.MaxLeaseTTL != "24h" {
+		t.Errorf("max lease TTL was '%s' instead of '24h'", sm.mi.Config.MaxLeaseTTL)
+	}
+
+	sm = &StubDefaultingMounter{}
+	if err := Mount(sm, "pki/", &MountConfiguration{Type: "pki"}); err != nil {
+		t.Error(err)
+	}
+	if sm.mi.Config.DefaultLeaseTTL != "" {
+		t.Errorf("default lease TTL was '%s' instead of ''", sm.mi.Config.DefaultLeaseTTL)
+	}
+}
+
 type StubMountLister struct {
 	returnMiss bool
 	returnErr  bool
@@ -201,14 +264,45 @@ func (w *StubCubbyholeWriter) Write(client *vault.Client, token string, data map
 	return secret, nil
 }
 
+// configuredWriteClient returns the configuration of a client whose settings
+// should carry over to the clients created for writes.
+func configuredWriteClient() *vault.Config {
+	return &vault.Config{
+		Address:      "https://vault.example.com:8200",
+		MaxRetries:   5,
+		MinRetryWait: 2 * time.Second,
+		MaxRetryWait: 30 * time.Second,
+	}
+}
+
+// checkWriteClientConfig checks that the settings from configuredWriteClient
+// carried over to the config used to create a write client.
+func checkWriteClientConfig(t *testing.T, cfg *vault.Config) {
+	t.Helper()
+	expected := configuredWriteClient()
+	if cfg.Address != expected.Address {
+		t.Errorf("address was '%s' instead of '%s'", cfg.Address, expected.Address)
+	}
+	if cfg.MaxRetries != expected.MaxRetries {
+		t.Errorf("max retries was %d instead of %d", cfg.MaxRetries, expected.MaxRetries)
+	}
+	if cfg.MinRetryWait != expected.MinRetryWait {
+		t.Errorf("min retry wait was %s instead of %s", cfg.MinRetryWait, expected.MinRetryWait)
+	}
+	if cfg.MaxRetryWait != expected.MaxRetryWait {
+		t.Errorf("max retry wait was %s instead of %s", cfg.MaxRetryWait, expected.MaxRetryWait)
+	}
+}
+
 func TestWriteMount1(t *testing.T) {
-	sw := &StubCubbyholeWriter{cfg: &vault.Config{}}
+	sw := &StubCubbyholeWriter{cfg: configuredWriteClient()}
 	err := WriteMount(sw, fmt.Sprintf("cubbyhole/%s", "token"), "token", map[string]interface{}{
 		"irods-config": "content",
 	})
 	if err != nil {
 		t.Error(err)
 	}
+	checkWriteClientConfig(t, sw.cfg)
 
 	sw = &StubCubbyholeWriter{cfg: &vault.Config{}, clientError: true}
 	err = WriteMount(sw, fmt.Sprintf("cubbyhole/%s", "token"), "token", map[string]interface{}{
@@ -227,6 +321,61 @@ func TestWriteMount1(t *testing.T) {
 	}
 }
 
+type StubLeaseWriter struct {
+	StubCubbyholeWriter
+}
+
+func (w *StubLeaseWriter) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	w.path = path
+	if _, err := w.StubCubbyholeWriter.Write(client, path, data); err != nil {
+		return nil, err
+	}
+	return &vault.Secret{
+		LeaseID:       fmt.Sprintf("%s/1234", path),
+		LeaseDuration: 3600,
+		Renewable:     true,
+	}, nil
+}
+
+func TestWriteMountWithLease(t *testing.T) {
+	sw := &StubLeaseWriter{StubCubbyholeWriter{cfg: configuredWriteClient()}}
+	data := map[string]interface{}{
+		"api-token": "content",
+	}
+	secret, err := WriteMountWithLease(sw, "secret/third-party", "token", data, "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWriteClientConfig(t, sw.cfg)
+	if sw.data["ttl"] != "1h" {
+		t.Errorf("ttl was '%s' instead of '1h'", sw.data["ttl"])
+	}
+	if sw.data["api-token"] != "content" {
+		t.Errorf("api-token was '%s' instead of 'content'", sw.data["api-token"])
+	}
+	if _, ok := data["ttl"]; ok {
+		t.Error("the caller's data map was modified")
+	}
+	if sw.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", sw.token)
+	}
+	if secret.LeaseID != "secret/third-party/1234" {
+		t.Errorf("lease ID was '%s' instead of 'secret/third-party/1234'", secret.LeaseID)
+	}
+	if secret.LeaseDuration != 3600 {
+		t.Errorf("lease duration was %d instead of 3600", secret.LeaseDuration)
+	}
+
+	sw = &StubLeaseWriter{StubCubbyholeWriter{cfg: &vault.Config{}, writeError: true}}
+	secret, err = WriteMountWithLease(sw, "secret/third-party", "token", data, "1h")
+	if err == nil {
+		t.Error("err was nil")
+	}
+	if secret != nil {
+		t.Error("secret was not nil after a write error")
+	}
+}
+
 type StubCubbyholeReader struct {
 	cfg            *vault.Config
 	token          string
@@ -319,8 +468,8 @@ func TestReadFromCubbyhole(t *testing.T) {
 		secretError: true,
 	}
 	s, err = ReadMount(sr, fmt.Sprintf("cubbyhole/%s", "token"), "token")
-	if err == nil {
-		t.Error(err)
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("err was '%v' instead of ErrSecretNotFound", err)
 	}
 	if s != nil {
 		t.Error("secret was not empty after a client creation error")
@@ -348,3 +497,831 @@ func TestReadFromCubbyhole(t *testing.T) {
 		t.Error("secret was not empty after a client creation error")
 	}
 }
+
+func TestTTLToSeconds(t *testing.T) {
+	cases := map[string]int{
+		"":          0,
+		"86400":     86400,
+		"24h":       86400,
+		"1h30m":     5400,
+		"768h":      2764800,
+		"30d":       2592000,
+		"90s":       90,
+		"8760h0m0s": 31536000,
+	}
+	for ttl, expected := range cases {
+		actual, err := TTLToSeconds(ttl)
+		if err != nil {
+			t.Error(err)
+		}
+		if actual != expected {
+			t.Errorf("TTLToSeconds(%q) => %d, expected => %d", ttl, actual, expected)
+		}
+	}
+
+	for _, ttl := range []string{"forever", "-1", "-5m", "xd"} {
+		if _, err := TTLToSeconds(ttl); err == nil {
+			t.Errorf("err was nil for TTL %q", ttl)
+		}
+	}
+}
+
+func TestSecondsToTTLRoundTrip(t *testing.T) {
+	for _, secs := range []int{0, 1, 90, 3600, 86400, 2764800, 31536000} {
+		ttl := SecondsToTTL(secs)
+		actual, err := TTLToSeconds(ttl)
+		if err != nil {
+			t.Error(err)
+		}
+		if actual != secs {
+			t.Errorf("round trip of %d through %q produced %d", secs, ttl, actual)
+		}
+	}
+
+	for _, ttl := range []string{"24h", "86400", "30d", "768h"} {
+		secs, err := TTLToSeconds(ttl)
+		if err != nil {
+			t.Error(err)
+		}
+		again, err := TTLToSeconds(SecondsToTTL(secs))
+		if err != nil {
+			t.Error(err)
+		}
+		if again != secs {
+			t.Errorf("round trip of %q produced %d instead of %d", ttl, again, secs)
+		}
+	}
+}
+
+func TestParseMountConfig(t *testing.T) {
+	mc := ParseMountConfig(&vault.MountConfigOutput{
+		DefaultLeaseTTL: 86400,
+		MaxLeaseTTL:     0,
+	})
+	if mc.DefaultLeaseTTL != "24h0m0s" {
+		t.Errorf("DefaultLeaseTTL was '%s' instead of '24h0m0s'", mc.DefaultLeaseTTL)
+	}
+	if mc.MaxLeaseTTL != "" {
+		t.Errorf("MaxLeaseTTL was '%s' instead of ''", mc.MaxLeaseTTL)
+	}
+	if mc = ParseMountConfig(nil); mc != nil {
+		t.Errorf("the config was %v instead of nil", mc)
+	}
+}
+
+// StubMountConfigTuner keeps the config of each mount and applies the TTLs it's
+// tuned with.
+type StubMountConfigTuner struct {
+	configs     map[string]*vault.MountConfigOutput
+	tuned       []vault.MountConfigInput
+	configError map[string]bool
+	tuneError   bool
+}
+
+func newStubMountConfigTuner() *StubMountConfigTuner {
+	return &StubMountConfigTuner{
+		configs: map[string]*vault.MountConfigOutput{
+			"pki":    {DefaultLeaseTTL: 86400, MaxLeaseTTL: 2592000},
+			"secret": {DefaultLeaseTTL: 3600, MaxLeaseTTL: 86400},
+		},
+		configError: map[string]bool{},
+	}
+}
+
+func (s *StubMountConfigTuner) MountConfig(path string) (*vault.MountConfigOutput, error) {
+	if s.configError[path] {
+		return nil, errors.New("mount config error")
+	}
+	return s.configs[path], nil
+}
+
+func (s *StubMountConfigTuner) TuneMount(path string, in vault.MountConfigInput) error {
+	s.tuned = append(s.tuned, in)
+	if s.tuneError {
+		return errors.New("tune error")
+	}
+	cfg := s.configs[path]
+	if in.DefaultLeaseTTL != "" {
+		cfg.DefaultLeaseTTL, _ = TTLToSeconds(in.DefaultLeaseTTL)
+	}
+	if in.MaxLeaseTTL != "" {
+		cfg.MaxLeaseTTL, _ = TTLToSeconds(in.MaxLeaseTTL)
+	}
+	return nil
+}
+
+func TestEnsureTuned(t *testing.T) {
+	s := newStubMountConfigTuner()
+	changed, err := EnsureTuned(s, "pki", &MountConfiguration{DefaultLeaseTTL: "1d", MaxLeaseTTL: "720h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || len(s.tuned) != 0 {
+		t.Errorf("the mount was tuned with %v when it already matched", s.tuned)
+	}
+
+	changed, err = EnsureTuned(s, "pki", &MountConfiguration{MaxLeaseTTL: "60d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || len(s.tuned) != 1 {
+		t.Fatalf("the mount was tuned with %v instead of once", s.tuned)
+	}
+	if s.tuned[0].DefaultLeaseTTL != "" || s.tuned[0].MaxLeaseTTL != "60d" {
+		t.Errorf("the mount was tuned with %+v instead of a max lease TTL of 60d", s.tuned[0])
+	}
+	if s.configs["pki"].MaxLeaseTTL != 5184000 {
+		t.Errorf("max lease TTL was %d instead of 5184000", s.configs["pki"].MaxLeaseTTL)
+	}
+
+	if _, err = EnsureTuned(s, "pki", &MountConfiguration{MaxLeaseTTL: "forever"}); err == nil {
+		t.Error("err was nil for an invalid TTL")
+	}
+	s.configError["pki"] = true
+	if _, err = EnsureTuned(s, "pki", &MountConfiguration{MaxLeaseTTL: "1h"}); err == nil {
+		t.Error("err was nil when the config couldn't be read")
+	}
+	if _, err = EnsureTuned(s, "missing", &MountConfiguration{MaxLeaseTTL: "1h"}); err == nil {
+		t.Error("err was nil when no config was returned")
+	}
+	s.tuneError = true
+	changed, err = EnsureTuned(s, "secret", &MountConfiguration{MaxLeaseTTL: "1h"})
+	if err == nil {
+		t.Error("err was nil when the tune failed")
+	}
+	if changed {
+		t.Error("changed was true when the tune failed")
+	}
+}
+
+type reconcileResult struct {
+	path    string
+	changed bool
+	failed  bool
+}
+
+func TestReconcileMounts(t *testing.T) {
+	s := newStubMountConfigTuner()
+	desired := map[string]MountConfiguration{
+		"secret": {MaxLeaseTTL: "24h"},
+		"pki":    {MaxLeaseTTL: "60d"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The fake clock runs the loop three times, drifting the pki mount and
+	// breaking the secret mount after the first pass, then cancels.
+	var sleeps []time.Duration
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		switch len(sleeps) {
+		case 1:
+			s.configs["pki"].MaxLeaseTTL = 3600
+			s.configError["secret"] = true
+		case 3:
+			cancel()
+		}
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = orig })
+
+	var results []reconcileResult
+	ReconcileMounts(ctx, s, desired, time.Minute, func(path string, changed bool, err error) {
+		results = append(results, reconcileResult{path, changed, err != nil})
+	})
+
+	expected := []reconcileResult{
+		{"pki", true, false},
+		{"secret", false, false},
+		{"pki", true, false},
+		{"secret", false, true},
+		{"pki", false, false},
+		{"secret", false, true},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("results were %v instead of %v", results, expected)
+	}
+	if len(sleeps) != 3 {
+		t.Errorf("the loop waited %d times instead of 3", len(sleeps))
+	}
+	for i, d := range sleeps {
+		if d != time.Minute {
+			t.Errorf("wait %d was %s instead of 1m", i, d)
+		}
+	}
+	if s.configs["pki"].MaxLeaseTTL != 5184000 {
+		t.Errorf("max lease TTL was %d instead of 5184000", s.configs["pki"].MaxLeaseTTL)
+	}
+}
+
+func TestTTLEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"24h", "24h0m0s", true},
+		{"1d", "86400", true},
+		{"", "0", true},
+		{"30m", "1800", true},
+		{"24h", "25h", false},
+		{"", "1h", false},
+	} {
+		equal, err := TTLEqual(c.a, c.b)
+		if err != nil {
+			t.Error(err)
+		}
+		if equal != c.expected {
+			t.Errorf("TTLEqual(%q, %q) => %t, expected => %t", c.a, c.b, equal, c.expected)
+		}
+	}
+
+	if _, err := TTLEqual("24h", "bogus"); err == nil {
+		t.Error("err was nil for an invalid TTL")
+	}
+	if _, err := TTLEqual("-1h", "1h"); err == nil {
+		t.Error("err was nil for a negative TTL")
+	}
+}
+
+type StubPluginMountLister struct {
+	returnErr bool
+}
+
+func (s *StubPluginMountLister) ListMounts() (map[string]*vault.MountOutput, error) {
+	if s.returnErr {
+		return nil, errors.New("test error")
+	}
+	return map[string]*vault.MountOutput{
+		"cubbyhole/": &vault.MountOutput{
+			Type:        "cubbyhole",
+			Description: "A cubbyhole mount for iRODS configs",
+		},
+		"custom/": &vault.MountOutput{
+			Type:           "custom-plugin",
+			PluginVersion:  "v1.2.0",
+			RunningVersion: "v1.1.0",
+			RunningSha256:  "abc123",
+		},
+	}, nil
+}
+
+func TestPluginVersion(t *testing.T) {
+	lister := &StubPluginMountLister{}
+	pv, err := PluginVersion(lister, "custom")
+	if err != nil {
+		t.Error(err)
+	}
+	if pv.PluginVersion != "v1.2.0" {
+		t.Errorf("PluginVersion was '%s' instead of 'v1.2.0'", pv.PluginVersion)
+	}
+	if pv.RunningPluginVersion != "v1.1.0" {
+		t.Errorf("RunningPluginVersion was '%s' instead of 'v1.1.0'", pv.RunningPluginVersion)
+	}
+	if pv.RunningSha256 != "abc123" {
+		t.Errorf("RunningSha256 was '%s' instead of 'abc123'", pv.RunningSha256)
+	}
+
+	pv, err = PluginVersion(lister, "cubbyhole/")
+	if err != nil {
+		t.Error(err)
+	}
+	if pv.PluginVersion != "" || pv.RunningPluginVersion != "" {
+		t.Errorf("builtin mount had plugin versions %+v", pv)
+	}
+
+	if _, err = PluginVersion(lister, "missing"); err == nil {
+		t.Error("err was nil for a missing mount")
+	}
+
+	lister = &StubPluginMountLister{returnErr: true}
+	if _, err = PluginVersion(lister, "custom"); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestIsMountedType(t *testing.T) {
+	lister := &StubPluginMountLister{}
+	m, err := IsMountedType(lister, "cubbyhole", "cubbyhole")
+	if err != nil {
+		t.Error(err)
+	}
+	if !m {
+		t.Error("the cubbyhole mount was not found")
+	}
+
+	m, err = IsMountedType(lister, "cubbyhole", "pki")
+	if err != nil {
+		t.Error(err)
+	}
+	if m {
+		t.Error("the cubbyhole mount was reported as a pki backend")
+	}
+
+	m, err = IsMountedType(lister, "pki", "pki")
+	if err != nil {
+		t.Error(err)
+	}
+	if m {
+		t.Error("the missing pki backend was found")
+	}
+
+	lister = &StubPluginMountLister{returnErr: true}
+	m, err = IsMountedType(lister, "cubbyhole", "cubbyhole")
+	if err == nil {
+		t.Error("err was nil")
+	}
+	if m {
+		t.Error("the cubbyhole mount was found")
+	}
+}
+
+func TestReadRawSecret(t *testing.T) {
+	body := `{"request_id":"1234","lease_id":"","renewable":false,"lease_duration":0,"data":{"irods-config":"foo"},"wrap_info":null,"warnings":null,"auth":null}`
+	var requestPath string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		switch r.URL.Path {
+		case "/v1/cubbyhole/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, body)
+		case "/v1/cubbyhole/error":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"errors":["internal error"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[]}`)
+		}
+	})
+	getter := &StubClientGetter{client: client}
+
+	raw, secret, err := ReadRawSecret(getter, "cubbyhole/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestPath != "/v1/cubbyhole/token" {
+		t.Errorf("request path was '%s' instead of '/v1/cubbyhole/token'", requestPath)
+	}
+	if string(raw) != body {
+		t.Errorf("raw body was '%s' instead of '%s'", raw, body)
+	}
+	if secret == nil {
+		t.Fatal("secret was nil")
+	}
+	if secret.Data["irods-config"] != "foo" {
+		t.Errorf("irods-config was '%s' instead of 'foo'", secret.Data["irods-config"])
+	}
+
+	raw, secret, err = ReadRawSecret(getter, "cubbyhole/missing")
+	if err != nil {
+		t.Error(err)
+	}
+	if secret != nil {
+		t.Error("secret was not nil for a missing path")
+	}
+	if string(raw) != `{"errors":[]}` {
+		t.Errorf("raw body was '%s' for a missing path", raw)
+	}
+
+	raw, secret, err = ReadRawSecret(getter, "cubbyhole/error")
+	if err == nil {
+		t.Error("err was nil")
+	}
+	if raw != nil || secret != nil {
+		t.Error("raw body or secret was set after an error")
+	}
+}
+
+type StubMountInfoReader struct {
+	StubPluginMountLister
+	path       string
+	readStatus int // The status of the error response to reads. Reads succeed if 0.
+	listed     bool
+}
+
+func (s *StubMountInfoReader) Client() *vault.Client {
+	return &vault.Client{}
+}
+
+func (s *StubMountInfoReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	s.path = path
+	if s.readStatus != 0 {
+		return nil, &vault.ResponseError{StatusCode: s.readStatus, Errors: []string{"unsupported path"}}
+	}
+	if path != "sys/mounts/custom" {
+		return nil, nil
+	}
+	return &vault.Secret{
+		Data: map[string]interface{}{
+			"type":                   "custom-plugin",
+			"description":            "a custom plugin",
+			"accessor":               "custom_1234",
+			"local":                  true,
+			"plugin_version":         "v1.2.0",
+			"running_plugin_version": "v1.2.0",
+			"options":                map[string]interface{}{"version": "2"},
+			"config": map[string]interface{}{
+				"default_lease_ttl": json.Number("3600"),
+				"max_lease_ttl":     json.Number("86400"),
+			},
+		},
+	}, nil
+}
+
+func (s *StubMountInfoReader) ListMounts() (map[string]*vault.MountOutput, error) {
+	s.listed = true
+	return s.StubPluginMountLister.ListMounts()
+}
+
+func TestReadMountInfo(t *testing.T) {
+	r := &StubMountInfoReader{}
+	mo, err := ReadMountInfo(r, "custom/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.path != "sys/mounts/custom" {
+		t.Errorf("path was '%s' instead of 'sys/mounts/custom'", r.path)
+	}
+	if r.listed {
+		t.Error("ListMounts was called when the single read succeeded")
+	}
+	if mo.Type != "custom-plugin" {
+		t.Errorf("type was '%s' instead of 'custom-plugin'", mo.Type)
+	}
+	if mo.Accessor != "custom_1234" {
+		t.Errorf("accessor was '%s' instead of 'custom_1234'", mo.Accessor)
+	}
+	if !mo.Local {
+		t.Error("local was false")
+	}
+	if mo.Options["version"] != "2" {
+		t.Errorf("options were %v", mo.Options)
+	}
+	if mo.Config.DefaultLeaseTTL != 3600 || mo.Config.MaxLeaseTTL != 86400 {
+		t.Errorf("config was %+v", mo.Config)
+	}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		r = &StubMountInfoReader{readStatus: status}
+		mo, err = ReadMountInfo(r, "custom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.listed {
+			t.Errorf("ListMounts was not called after the single read failed with a %d", status)
+		}
+		if mo.RunningVersion != "v1.1.0" {
+			t.Errorf("running version was '%s' instead of 'v1.1.0'", mo.RunningVersion)
+		}
+	}
+
+	// Other errors are returned without falling back.
+	r = &StubMountInfoReader{readStatus: http.StatusForbidden}
+	_, err = ReadMountInfo(r, "custom")
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+		t.Errorf("err was %v instead of the 403 response", err)
+	}
+	if r.listed {
+		t.Error("ListMounts was called after the single read was forbidden")
+	}
+
+	r = &StubMountInfoReader{}
+	if _, err = ReadMountInfo(r, "missing"); err == nil {
+		t.Error("err was nil for a missing mount")
+	}
+	if !r.listed {
+		t.Error("ListMounts was not called after the single read found nothing")
+	}
+
+	r = &StubMountInfoReader{readStatus: http.StatusNotFound}
+	r.returnErr = true
+	if _, err = ReadMountInfo(r, "custom"); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestMountDescriptionMatches(t *testing.T) {
+	lister := &StubPluginMountLister{}
+	matches, err := MountDescriptionMatches(lister, "cubbyhole", "A cubbyhole mount for iRODS configs")
+	if err != nil {
+		t.Error(err)
+	}
+	if !matches {
+		t.Error("the description did not match")
+	}
+
+	matches, err = MountDescriptionMatches(lister, "cubbyhole", "A new description")
+	if err != nil {
+		t.Error(err)
+	}
+	if matches {
+		t.Error("the description matched when it should have drifted")
+	}
+
+	if _, err = MountDescriptionMatches(lister, "missing", ""); err == nil {
+		t.Error("err was nil for a missing mount")
+	}
+
+	lister = &StubPluginMountLister{returnErr: true}
+	if _, err = MountDescriptionMatches(lister, "cubbyhole", ""); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestReloadPlugin(t *testing.T) {
+	rw := &StubMountReaderWriter{}
+	if err := ReloadPlugin(rw, "custom-plugin", "global"); err != nil {
+		t.Error(err)
+	}
+	if rw.path != "sys/plugins/reload/backend" {
+		t.Errorf("path was '%s' instead of 'sys/plugins/reload/backend'", rw.path)
+	}
+	if rw.data["plugin"] != "custom-plugin" {
+		t.Errorf("plugin was %v instead of custom-plugin", rw.data["plugin"])
+	}
+	if rw.data["scope"] != "global" {
+		t.Errorf("scope was %v instead of global", rw.data["scope"])
+	}
+
+	rw = &StubMountReaderWriter{}
+	if err := ReloadPlugin(rw, "custom-plugin", ""); err != nil {
+		t.Error(err)
+	}
+	if _, ok := rw.data["scope"]; ok {
+		t.Error("scope was set for a local reload")
+	}
+
+	rw = &StubMountReaderWriter{writeError: true}
+	if err := ReloadPlugin(rw, "custom-plugin", "global"); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
+type StubInventoryMountLister struct {
+	returnErr bool
+}
+
+func (s *StubInventoryMountLister) ListMounts() (map[string]*vault.MountOutput, error) {
+	if s.returnErr {
+		return nil, errors.New("test error")
+	}
+	return map[string]*vault.MountOutput{
+		"secret/": &vault.MountOutput{
+			Type:        "kv",
+			Accessor:    "kv_1234",
+			Description: "key/value secret storage",
+			Options:     map[string]string{"version": "2"},
+		},
+		"pki/": &vault.MountOutput{
+			Type:     "pki",
+			Accessor: "pki_5678",
+			Local:    true,
+			SealWrap: true,
+			Config: vault.MountConfigOutput{
+				DefaultLeaseTTL: 3600,
+				MaxLeaseTTL:     86400,
+			},
+		},
+		"custom/": &vault.MountOutput{
+			Type:          "custom-plugin",
+			Accessor:      "custom_9abc",
+			PluginVersion: "v1.2.0",
+		},
+	}, nil
+}
+
+func TestInventoryMounts(t *testing.T) {
+	inventory, err := InventoryMounts(&StubInventoryMountLister{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 3 {
+		t.Fatalf("inventory had %d entries instead of 3", len(inventory))
+	}
+	paths := []string{"custom/", "pki/", "secret/"}
+	for i, p := range paths {
+		if inventory[i].Path != p {
+			t.Errorf("entry %d had path '%s' instead of '%s'", i, inventory[i].Path, p)
+		}
+	}
+
+	custom := inventory[0]
+	if custom.Type != "custom-plugin" || custom.Accessor != "custom_9abc" || custom.PluginVersion != "v1.2.0" {
+		t.Errorf("custom/ was %+v", custom)
+	}
+
+	pki := inventory[1]
+	if pki.Type != "pki" || pki.Accessor != "pki_5678" {
+		t.Errorf("pki/ was %+v", pki)
+	}
+	if !pki.Local || !pki.SealWrap {
+		t.Error("pki/ was not local and seal wrapped")
+	}
+	if pki.DefaultLeaseTTL != "1h0m0s" {
+		t.Errorf("DefaultLeaseTTL was '%s' instead of '1h0m0s'", pki.DefaultLeaseTTL)
+	}
+	if pki.MaxLeaseTTL != "24h0m0s" {
+		t.Errorf("MaxLeaseTTL was '%s' instead of '24h0m0s'", pki.MaxLeaseTTL)
+	}
+
+	kv := inventory[2]
+	if kv.Type != "kv" || kv.Description != "key/value secret storage" {
+		t.Errorf("secret/ was %+v", kv)
+	}
+	if kv.Options["version"] != "2" {
+		t.Errorf("secret/ version option was '%s' instead of '2'", kv.Options["version"])
+	}
+	if kv.DefaultLeaseTTL != "" || kv.MaxLeaseTTL != "" {
+		t.Error("secret/ TTLs were set when the system defaults are used")
+	}
+
+	if _, err = InventoryMounts(&StubInventoryMountLister{returnErr: true}); err == nil {
+		t.Error("err was nil for a list error")
+	}
+}
+
+func TestListPath(t *testing.T) {
+	l := &StubLister{keys: []interface{}{"htcondor", "irods"}}
+	keys, err := ListPath(l, "pki/roles")
+	if err != nil {
+		t.Error(err)
+	}
+	if l.path != "pki/roles" {
+		t.Errorf("path was '%s' instead of 'pki/roles'", l.path)
+	}
+	if len(keys) != 2 || keys[0] != "htcondor" || keys[1] != "irods" {
+		t.Errorf("keys were %v instead of [htcondor irods]", keys)
+	}
+
+	l = &StubLister{}
+	keys, err = ListPath(l, "pki/roles")
+	if err != nil {
+		t.Error(err)
+	}
+	if keys == nil || len(keys) != 0 {
+		t.Errorf("keys were %v instead of an empty slice", keys)
+	}
+
+	l = &StubLister{keys: []interface{}{"htcondor", 1}}
+	if _, err = ListPath(l, "pki/roles"); err == nil {
+		t.Error("err was nil for a non-string key")
+	}
+
+	l = &StubLister{listError: true}
+	if _, err = ListPath(l, "pki/roles"); err == nil {
+		t.Error("err was nil for a list error")
+	}
+}
+
+type StubUnmounter struct {
+	path         string
+	unmountError bool
+}
+
+func (s *StubUnmounter) Unmount(path string) error {
+	s.path = path
+	if s.unmountError {
+		return errors.New("no matching mount at 'tenant-a/pki/'")
+	}
+	return nil
+}
+
+func TestUnmount(t *testing.T) {
+	u := &StubUnmounter{}
+	if err := Unmount(u, "tenant-a/pki"); err != nil {
+		t.Error(err)
+	}
+	if u.path != "tenant-a/pki" {
+		t.Errorf("path was '%s' instead of 'tenant-a/pki'", u.path)
+	}
+
+	u = &StubUnmounter{unmountError: true}
+	if err := Unmount(u, "tenant-a/pki"); err == nil {
+		t.Error("err was nil for an unmount error")
+	}
+}
+
+type StubRemounter struct {
+	from         string
+	to           string
+	remountError bool
+}
+
+func (s *StubRemounter) Remount(from, to string) error {
+	s.from = from
+	s.to = to
+	if s.remountError {
+		return errors.New("remount error")
+	}
+	return nil
+}
+
+func TestRemount(t *testing.T) {
+	r := &StubRemounter{}
+	if err := Remount(r, "pki", "pki/tenant-a"); err != nil {
+		t.Error(err)
+	}
+	if r.from != "pki" {
+		t.Errorf("from was '%s' instead of 'pki'", r.from)
+	}
+	if r.to != "pki/tenant-a" {
+		t.Errorf("to was '%s' instead of 'pki/tenant-a'", r.to)
+	}
+
+	r = &StubRemounter{remountError: true}
+	if err := Remount(r, "pki", "pki/tenant-a"); err == nil {
+		t.Error("err was nil for a remount error")
+	}
+}
+
+type StubContextWriter struct {
+	StubCubbyholeWriter
+	ctx context.Context
+}
+
+func (w *StubContextWriter) WriteWithContext(ctx context.Context, client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	w.ctx = ctx
+	w.path = path
+	return w.Write(client, path, data)
+}
+
+func TestWriteMountWithContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey{}, "write")
+	sw := &StubContextWriter{StubCubbyholeWriter: StubCubbyholeWriter{cfg: configuredWriteClient()}}
+	err := WriteMountWithContext(ctx, sw, "cubbyhole/token", "token", map[string]interface{}{
+		"irods-config": "content",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	checkWriteClientConfig(t, sw.cfg)
+	if sw.ctx != ctx {
+		t.Error("the context was not passed to the writer")
+	}
+	if sw.path != "cubbyhole/token" {
+		t.Errorf("path was '%s' instead of 'cubbyhole/token'", sw.path)
+	}
+	if sw.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", sw.token)
+	}
+	if sw.data["irods-config"] != "content" {
+		t.Errorf("irods-config was %v instead of content", sw.data["irods-config"])
+	}
+
+	sw = &StubContextWriter{StubCubbyholeWriter: StubCubbyholeWriter{cfg: &vault.Config{}, clientError: true}}
+	if err = WriteMountWithContext(ctx, sw, "cubbyhole/token", "token", nil); err == nil {
+		t.Error("err was nil for a client error")
+	}
+	sw = &StubContextWriter{StubCubbyholeWriter: StubCubbyholeWriter{cfg: &vault.Config{}, writeError: true}}
+	if err = WriteMountWithContext(ctx, sw, "cubbyhole/token", "token", nil); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
+// testContextKey is used to tell test contexts apart.
+type testContextKey struct{}
+
+type StubContextReader struct {
+	StubCubbyholeReader
+	ctx context.Context
+}
+
+func (r *StubContextReader) ReadWithContext(ctx context.Context, client *vault.Client, path string) (*vault.Secret, error) {
+	r.ctx = ctx
+	return r.Read(client, path)
+}
+
+func TestReadMountWithContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey{}, "read")
+	sr := &StubContextReader{}
+	data, err := ReadMountWithContext(ctx, sr, "cubbyhole/token", "token")
+	if err != nil {
+		t.Error(err)
+	}
+	if sr.ctx != ctx {
+		t.Error("the context was not passed to the reader")
+	}
+	if sr.path != "cubbyhole/token" {
+		t.Errorf("path was '%s' instead of 'cubbyhole/token'", sr.path)
+	}
+	if sr.token != "token" {
+		t.Errorf("token was '%s' instead of 'token'", sr.token)
+	}
+	if data["irods-config"] != "foo" {
+		t.Errorf("irods-config was %v instead of foo", data["irods-config"])
+	}
+
+	sr = &StubContextReader{StubCubbyholeReader: StubCubbyholeReader{secretError: true}}
+	if _, err = ReadMountWithContext(ctx, sr, "cubbyhole/token", "token"); err == nil {
+		t.Error("err was nil for a nil secret")
+	}
+	sr = &StubContextReader{StubCubbyholeReader: StubCubbyholeReader{dataError: true}}
+	if _, err = ReadMountWithContext(ctx, sr, "cubbyhole/token", "token"); err == nil {
+		t.Error("err was nil for nil data")
+	}
+	sr = &StubContextReader{StubCubbyholeReader: StubCubbyholeReader{readError: true}}
+	if _, err = ReadMountWithContext(ctx, sr, "cubbyhole/token", "token"); err == nil {
+		t.Error("err was nil for a read error")
+	}
+}
@@ -0,0 +1,77 @@
+package vaulter
+
+import (
+	"errors"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// PolicyReader is an interface for objects that can read the rules for an ACL
+// policy.
+type PolicyReader interface {
+	GetPolicy(name string) (string, error)
+}
+
+// EffectivePolicyReader defines the interface for looking up a token's policies
+// and reading their rules.
+type EffectivePolicyReader interface {
+	TokenLookuper
+	PolicyReader
+}
+
+// tokenPolicies returns the names of the policies attached to the token
+// described by the lookup secret, including policies inherited through its
+// identity.
+func tokenPolicies(secret *vault.Secret) ([]string, error) {
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("no data was returned for the token lookup")
+	}
+	var (
+		names []string
+		seen  = map[string]bool{}
+	)
+	for _, key := range []string{"policies", "identity_policies"} {
+		v, ok := secret.Data[key]
+		if !ok || v == nil {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s has unexpected type %T", key, v)
+		}
+		for _, p := range list {
+			name, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("policy %v is not a string", p)
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// EffectivePolicyDocs looks up the token and returns the HCL rules for each of
+// the policies that apply to it, keyed by policy name.
+func EffectivePolicyDocs(v EffectivePolicyReader, token string) (map[string]string, error) {
+	secret, err := v.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	names, err := tokenPolicies(secret)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]string, len(names))
+	for _, name := range names {
+		rules, err := v.GetPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		docs[name] = rules
+	}
+	return docs, nil
+}
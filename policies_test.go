@@ -0,0 +1,59 @@
+package vaulter
+
+import (
+	"errors"
+	"testing"
+)
+
+type StubEffectivePolicyReader struct {
+	StubTokenLookuper
+	policies    map[string]string
+	policyError bool
+}
+
+func (s *StubEffectivePolicyReader) GetPolicy(name string) (string, error) {
+	if s.policyError {
+		return "", errors.New("policy error")
+	}
+	return s.policies[name], nil
+}
+
+func TestEffectivePolicyDocs(t *testing.T) {
+	r := &StubEffectivePolicyReader{
+		StubTokenLookuper: StubTokenLookuper{
+			data: map[string]interface{}{
+				"policies":          []interface{}{"default", "jobs"},
+				"identity_policies": []interface{}{"jobs"},
+			},
+		},
+		policies: map[string]string{
+			"default": `path "auth/token/lookup-self" { capabilities = ["read"] }`,
+			"jobs":    `path "cubbyhole/*" { capabilities = ["read"] }`,
+		},
+	}
+	docs, err := EffectivePolicyDocs(r, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.token != "token" {
+		t.Errorf("looked up token was '%s' instead of 'token'", r.token)
+	}
+	if len(docs) != 2 {
+		t.Errorf("docs had %d entries instead of 2", len(docs))
+	}
+	for name, expected := range r.policies {
+		if docs[name] != expected {
+			t.Errorf("docs[\"%s\"] => %s, expected => %s", name, docs[name], expected)
+		}
+	}
+
+	r.policyError = true
+	if _, err = EffectivePolicyDocs(r, "token"); err == nil {
+		t.Error("err was nil for a policy read error")
+	}
+
+	r = &StubEffectivePolicyReader{StubTokenLookuper: StubTokenLookuper{lookupError: true}}
+	if _, err = EffectivePolicyDocs(r, "token"); err == nil {
+		t.Error("err was nil for a lookup error")
+	}
+}
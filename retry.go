@@ -0,0 +1,159 @@
+package vaulter
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Jitter selects how the waits between retries are randomized so that clients
+// that failed together don't retry in lockstep.
+type Jitter int
+
+const (
+	// FullJitter waits a random time between 0 and the backoff. It's the
+	// default.
+	FullJitter Jitter = iota
+
+	// EqualJitter waits half of the backoff plus a random time up to the other
+	// half.
+	EqualJitter
+
+	// NoJitter waits exactly the backoff.
+	NoJitter
+)
+
+// RetryConfig contains the settings for retrying operations that fail with
+// transient errors.
+type RetryConfig struct {
+	MaxAttempts int           // The total number of attempts, including the first. Values below 1 mean 1.
+	BaseDelay   time.Duration // The backoff before the first retry. Doubles after each retry.
+	MaxDelay    time.Duration // The longest backoff between attempts. No limit if 0.
+	Jitter      Jitter        // How the backoff is randomized. FullJitter if unset.
+}
+
+// sleep waits between attempts, returning early with the context's error if
+// the context is done first. A variable so tests don't have to wait.
+var sleep = sleepContext
+
+// sleepContext waits for d or until the context is done, whichever comes
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay returns how long to wait before the given retry, counting from 1, with
+// the jitter applied to the backoff.
+func (c RetryConfig) delay(retry int) time.Duration {
+	d := c.backoff(retry)
+	switch c.Jitter {
+	case NoJitter:
+		return d
+	case EqualJitter:
+		return d/2 + randDuration(d-d/2)
+	default:
+		return randDuration(d)
+	}
+}
+
+// randDuration returns a random duration between 0 and max, inclusive.
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// backoff returns the backoff before the given retry without any jitter.
+func (c RetryConfig) backoff(retry int) time.Duration {
+	d := c.BaseDelay
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if c.MaxDelay > 0 && d >= c.MaxDelay {
+			break
+		}
+	}
+	if c.MaxDelay > 0 && d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+	return d
+}
+
+// isTransient returns true if the error is worth retrying: a 5xx or 429
+// response from Vault or a network error. Other 4xx responses are returned as
+// is.
+func isTransient(err error) bool {
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500 || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// WithRetry calls fn until it succeeds, it returns an error that isn't
+// transient, or the attempts run out, backing off exponentially with jitter
+// between attempts. Vault 5xx and 429 responses and network errors are
+// transient. The last error is returned. A Vault client set up by InitAPI has
+// already retried a 429 after the wait given in its Retry-After header, as far
+// as the client's MaxRetries allows, so these retries back off on top of that.
+func WithRetry(cfg RetryConfig, fn func() error) error {
+	return withRetry(context.Background(), cfg, fn)
+}
+
+// withRetry retries fn the same way as WithRetry, giving up when the context
+// is done, including partway through a backoff.
+func withRetry(ctx context.Context, cfg RetryConfig, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !isTransient(err) || attempt >= cfg.MaxAttempts {
+			return err
+		}
+		if ctx.Err() != nil || sleep(ctx, cfg.delay(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// retriedOperations lists the operations retried by RetryMiddleware.
+var retriedOperations = map[string]bool{
+	"read":  true,
+	"write": true,
+	"list":  true,
+}
+
+// RetryMiddleware returns a Middleware that retries read, write, and list
+// operations that fail with transient errors, as described for WithRetry.
+// Other operations are passed through. Add it to a VaultAPI with Use() to opt
+// in.
+func RetryMiddleware(cfg RetryConfig) Middleware {
+	return func(next OpFunc) OpFunc {
+		return func(op *Operation) error {
+			if !retriedOperations[op.Name] {
+				return next(op)
+			}
+			ctx := op.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			return withRetry(ctx, cfg, func() error {
+				return next(op)
+			})
+		}
+	}
+}
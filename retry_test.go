@@ -0,0 +1,234 @@
+package vaulter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// recordSleeps replaces sleep for the duration of the test and returns the
+// waits that were requested.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	var sleeps []time.Duration
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = orig })
+	return &sleeps
+}
+
+// failingFunc returns a func that fails with err the first n times it's called.
+func failingFunc(n int, err error, calls *int) func() error {
+	return func() error {
+		*calls++
+		if *calls <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	sleeps := recordSleeps(t)
+	cfg := RetryConfig{
+		MaxAttempts: 6,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    300 * time.Millisecond,
+		Jitter:      NoJitter,
+	}
+	var calls int
+	unavailable := &vault.ResponseError{StatusCode: http.StatusServiceUnavailable}
+	if err := WithRetry(cfg, failingFunc(4, unavailable, &calls)); err != nil {
+		t.Error(err)
+	}
+	if calls != 5 {
+		t.Errorf("fn was called %d times instead of 5", calls)
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		300 * time.Millisecond,
+		300 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(*sleeps, expected) {
+		t.Errorf("sleeps were %v instead of %v", *sleeps, expected)
+	}
+}
+
+func TestWithRetryGivesUp(t *testing.T) {
+	sleeps := recordSleeps(t)
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	var calls int
+	unavailable := &vault.ResponseError{StatusCode: http.StatusBadGateway}
+	if err := WithRetry(cfg, failingFunc(5, unavailable, &calls)); err != unavailable {
+		t.Errorf("err was %v instead of %v", err, unavailable)
+	}
+	if calls != 3 {
+		t.Errorf("fn was called %d times instead of 3", calls)
+	}
+	if len(*sleeps) != 2 {
+		t.Errorf("there were %d sleeps instead of 2", len(*sleeps))
+	}
+}
+
+func TestWithRetryNotTransient(t *testing.T) {
+	recordSleeps(t)
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	for _, err := range []error{
+		&vault.ResponseError{StatusCode: http.StatusForbidden},
+		&vault.ResponseError{StatusCode: http.StatusNotFound},
+		errors.New("some other error"),
+	} {
+		var calls int
+		if actual := WithRetry(cfg, failingFunc(1, err, &calls)); actual != err {
+			t.Errorf("err was %v instead of %v", actual, err)
+		}
+		if calls != 1 {
+			t.Errorf("fn was called %d times for %v instead of once", calls, err)
+		}
+	}
+}
+
+func TestWithRetryRateLimited(t *testing.T) {
+	recordSleeps(t)
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	var calls int
+	limited := &vault.ResponseError{StatusCode: http.StatusTooManyRequests}
+	if err := WithRetry(cfg, failingFunc(2, limited, &calls)); err != nil {
+		t.Error(err)
+	}
+	if calls != 3 {
+		t.Errorf("fn was called %d times instead of 3", calls)
+	}
+}
+
+func TestWithRetryNetworkError(t *testing.T) {
+	recordSleeps(t)
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	var calls int
+	netErr := fmt.Errorf("request failed: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	if err := WithRetry(cfg, failingFunc(2, netErr, &calls)); err != nil {
+		t.Error(err)
+	}
+	if calls != 3 {
+		t.Errorf("fn was called %d times instead of 3", calls)
+	}
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	recordSleeps(t)
+	var failures int32 = 2
+	var requests int32
+	client := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"errors":["leader election in progress"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"foo":"bar"}}`)
+	})
+	api := &VaultAPI{}
+	api.SetClient(client)
+	api.Use(RetryMiddleware(RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+
+	secret, err := api.Read(client, "secret/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["foo"] != "bar" {
+		t.Errorf("foo was %v instead of bar", secret.Data["foo"])
+	}
+	if requests != 3 {
+		t.Errorf("there were %d requests instead of 3", requests)
+	}
+
+	// Operations other than read, write, and list aren't retried.
+	atomic.StoreInt32(&failures, 1)
+	atomic.StoreInt32(&requests, 0)
+	if _, err = api.Delete(client, "secret/foo"); err == nil {
+		t.Error("err was nil for a failed delete")
+	}
+	if requests != 1 {
+		t.Errorf("there were %d requests instead of 1", requests)
+	}
+}
+
+func TestWithRetryCancelledDuringBackoff(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Hour, Jitter: NoJitter}
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	unavailable := &vault.ResponseError{StatusCode: http.StatusServiceUnavailable}
+	done := make(chan error, 1)
+	go func() {
+		done <- withRetry(ctx, cfg, func() error {
+			calls++
+			return unavailable
+		})
+	}()
+
+	// Give the first attempt time to fail and start backing off.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != unavailable {
+			t.Errorf("err was %v instead of %v", err, unavailable)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withRetry didn't return when the context was cancelled during a backoff")
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times instead of once", calls)
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	cfg := RetryConfig{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	checks := []struct {
+		jitter   Jitter
+		retry    int
+		min, max time.Duration
+	}{
+		{FullJitter, 1, 0, 100 * time.Millisecond},
+		{FullJitter, 3, 0, 400 * time.Millisecond},
+		{FullJitter, 10, 0, time.Second},
+		{EqualJitter, 1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{EqualJitter, 3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{EqualJitter, 10, 500 * time.Millisecond, time.Second},
+		{NoJitter, 3, 400 * time.Millisecond, 400 * time.Millisecond},
+	}
+	for _, c := range checks {
+		cfg.Jitter = c.jitter
+		distinct := map[time.Duration]bool{}
+		for i := 0; i < 1000; i++ {
+			d := cfg.delay(c.retry)
+			if d < c.min || d > c.max {
+				t.Fatalf("jitter %d retry %d: delay %s was outside [%s, %s]", c.jitter, c.retry, d, c.min, c.max)
+			}
+			distinct[d] = true
+		}
+		if c.jitter != NoJitter && len(distinct) < 100 {
+			t.Errorf("jitter %d retry %d: only %d distinct delays in 1000 samples", c.jitter, c.retry, len(distinct))
+		}
+	}
+
+	// Full jitter is the default.
+	if (RetryConfig{}).Jitter != FullJitter {
+		t.Error("the default jitter wasn't full jitter")
+	}
+	if d := (RetryConfig{}).delay(1); d != 0 {
+		t.Errorf("delay was %s instead of 0 without a base delay", d)
+	}
+}
@@ -1,35 +1,194 @@
 package vaulter
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"strconv"
+	"strings"
 
 	vault "github.com/hashicorp/vault/api"
 )
 
 // RoleConfig contains the settings applied to a new role.
 type RoleConfig struct {
-	AllowedDomains  string
-	AllowSubdomains bool
-	KeyBits         int
-	MaxTTL          string
-	AllowAnyName    bool
+	AllowedDomains    string
+	AllowSubdomains   bool
+	KeyBits           int
+	MaxTTL            string
+	AllowAnyName      bool
+	AllowedURISans    string   // csv of allowed URI subject alternative names, globs are permitted
+	KeyUsage          []string // e.g. DigitalSignature, KeyEncipherment. Vault's defaults are used if empty.
+	ExtKeyUsage       []string // e.g. ClientAuth, ServerAuth. Vault's defaults are used if empty.
+	AllowLocalhost    *bool    // Vault allows localhost by default, so this is only written when set.
+	AllowBareDomains  bool
+	NotBeforeDuration string // how far to backdate issued certs to tolerate clock skew, e.g. "30s"
+	TTL               string // the default TTL for issued certs
+	KeyType           string // rsa, ec, or any. Defaults to rsa if empty.
+
+	// Vault enables these by default, so they're only written when set.
+	AllowIPSans *bool
+	ServerFlag  *bool
+	ClientFlag  *bool
+
+	// AllowedSerialNumbers limits the subject serial numbers that may be
+	// requested. Globs are permitted. Any serial number is allowed if empty.
+	AllowedSerialNumbers []string
+
+	// AllowedUserIDs limits the UserID values that may be requested in the
+	// subject. Globs are permitted. No UserIDs are allowed if empty.
+	AllowedUserIDs []string
+
+	// Subject fields for certs issued by the role. Left to Vault's defaults if
+	// empty.
+	Organization  []string
+	OU            []string
+	Country       []string
+	Locality      []string
+	Province      []string
+	StreetAddress []string
+	PostalCode    []string
+}
+
+// RoleDefaults contains the role settings used when callers don't provide
+// their own.
+type RoleDefaults struct {
+	Role            string // The role used by IssueCert when no role name is given.
+	AllowedDomains  string // Used by CreateRole when no allowed domains are given.
+	AllowSubdomains bool   // Only applied along with AllowedDomains.
+	MaxTTL          string // Used by CreateRole when no max TTL is given.
+}
+
+// RoleDefaulter is an interface for objects that provide default role settings.
+type RoleDefaulter interface {
+	RoleDefaults() *RoleDefaults
+}
+
+// withDefaults returns a copy of the RoleConfig with its empty fields filled in
+// from the provided defaults.
+func (c *RoleConfig) withDefaults(d *RoleDefaults) *RoleConfig {
+	merged := *c
+	if d == nil {
+		return &merged
+	}
+	if merged.AllowedDomains == "" && d.AllowedDomains != "" {
+		merged.AllowedDomains = d.AllowedDomains
+		merged.AllowSubdomains = d.AllowSubdomains
+	}
+	if merged.MaxTTL == "" {
+		merged.MaxTTL = d.MaxTTL
+	}
+	return &merged
 }
 
-// CreateRole creates a new role.
+// CreateRole creates a new role. If the MountReaderWriter is also a
+// RoleDefaulter, the allowed domains and max TTL fall back to its defaults
+// when they're empty. The allowed domains, key bits, and the allow_subdomains,
+// allow_any_name, allowed_uri_sans, and allow_bare_domains settings are always
+// written, even when empty; use CreateRoleWithConfig to leave unset fields to
+// Vault's defaults. The key type and key bits are checked before anything is
+// written.
 func CreateRole(r MountReaderWriter, mountPath, roleName string, c *RoleConfig) (*vault.Secret, error) {
+	return createRole(r, mountPath, roleName, c, true)
+}
+
+// CreateRoleWithConfig creates a new role the same way as CreateRole, except
+// that only the fields set in the RoleConfig are written so that Vault applies
+// its own defaults for the rest. The key type is always written, since it
+// defaults to rsa.
+func CreateRoleWithConfig(r MountReaderWriter, mountPath, roleName string, cfg RoleConfig) (*vault.Secret, error) {
+	return createRole(r, mountPath, roleName, &cfg, false)
+}
+
+// createRole writes the role. If all is true, the fields that CreateRole has
+// always written are included even when they're empty.
+func createRole(r MountReaderWriter, mountPath, roleName string, c *RoleConfig, all bool) (*vault.Secret, error) {
+	if rd, ok := r.(RoleDefaulter); ok {
+		c = c.withDefaults(rd.RoleDefaults())
+	}
+	if c.KeyType != "any" {
+		if err := validateKeyParams(c.KeyType, c.KeyBits); err != nil {
+			return nil, err
+		}
+	}
 	client := r.Client()
 	writePath := fmt.Sprintf("%s/roles/%s", mountPath, roleName)
-	data := map[string]interface{}{
-		"allowed_domains":  c.AllowedDomains,
-		"allow_subdomains": strconv.FormatBool(c.AllowSubdomains),
-		"key_bits":         c.KeyBits,
-		"allow_any_name":   strconv.FormatBool(c.AllowAnyName),
+	data := roleData(c)
+	if all {
+		data["allowed_domains"] = c.AllowedDomains
+		data["allow_subdomains"] = strconv.FormatBool(c.AllowSubdomains)
+		data["key_bits"] = c.KeyBits
+		data["allow_any_name"] = strconv.FormatBool(c.AllowAnyName)
+		data["allowed_uri_sans"] = c.AllowedURISans
+		data["allow_bare_domains"] = strconv.FormatBool(c.AllowBareDomains)
 	}
 	return r.Write(client, writePath, data)
 }
 
+// roleData returns the data to write for the role, leaving out unset fields.
+func roleData(c *RoleConfig) map[string]interface{} {
+	data := map[string]interface{}{}
+	strs := map[string]string{
+		"allowed_domains":     c.AllowedDomains,
+		"allowed_uri_sans":    c.AllowedURISans,
+		"ttl":                 c.TTL,
+		"max_ttl":             c.MaxTTL,
+		"key_type":            keyTypeOrDefault(c.KeyType),
+		"not_before_duration": c.NotBeforeDuration,
+	}
+	for k, v := range strs {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	flags := map[string]bool{
+		"allow_subdomains":   c.AllowSubdomains,
+		"allow_any_name":     c.AllowAnyName,
+		"allow_bare_domains": c.AllowBareDomains,
+	}
+	for k, v := range flags {
+		if v {
+			data[k] = strconv.FormatBool(v)
+		}
+	}
+	optionalFlags := map[string]*bool{
+		"allow_localhost": c.AllowLocalhost,
+		"allow_ip_sans":   c.AllowIPSans,
+		"server_flag":     c.ServerFlag,
+		"client_flag":     c.ClientFlag,
+	}
+	for k, v := range optionalFlags {
+		if v != nil {
+			data[k] = strconv.FormatBool(*v)
+		}
+	}
+	if c.KeyBits != 0 {
+		data["key_bits"] = c.KeyBits
+	}
+	lists := map[string][]string{
+		"organization":           c.Organization,
+		"ou":                     c.OU,
+		"country":                c.Country,
+		"locality":               c.Locality,
+		"province":               c.Province,
+		"street_address":         c.StreetAddress,
+		"postal_code":            c.PostalCode,
+		"key_usage":              c.KeyUsage,
+		"ext_key_usage":          c.ExtKeyUsage,
+		"allowed_serial_numbers": c.AllowedSerialNumbers,
+		"allowed_user_ids":       c.AllowedUserIDs,
+	}
+	for k, v := range lists {
+		if len(v) > 0 {
+			data[k] = v
+		}
+	}
+	return data
+}
+
 // HasRole returns true if the passed in role exists and has the same settings.
+// The domains are a comma-separated list that's compared as a set against the
+// role's allowed_domains.
 func HasRole(r MountReaderWriter, mountPath, roleName, domains string, subdomains bool) (bool, error) {
 	client := r.Client()
 	readPath := fmt.Sprintf("%s/roles/%s", mountPath, roleName)
@@ -47,16 +206,356 @@ func HasRole(r MountReaderWriter, mountPath, roleName, domains string, subdomain
 	if !ok {
 		return false, nil
 	}
-	if v != domains {
+	actualDomains, err := dataStrings(v)
+	if err != nil {
+		return false, err
+	}
+	if !sameStringSet(actualDomains, splitCSV(domains)) {
 		return false, nil
 	}
 	v, ok = secret.Data["allow_subdomains"]
 	if !ok {
 		return false, nil
 	}
-	if v != subdomains {
-		fmt.Printf("v: %s\tsubdomains: %s\n", v, strconv.FormatBool(subdomains))
-		return false, nil
+	actual, err := dataBool(v)
+	if err != nil {
+		return false, err
+	}
+	return actual == subdomains, nil
+}
+
+// RoleAllowsDomain reads the role and returns true if it would allow a cert to
+// be issued for the domain, following Vault's rules: a domain matching one of
+// the allowed domains needs allow_bare_domains, a subdomain of one (including a
+// wildcard such as *.example.com) needs allow_subdomains, allowed domains with
+// globs need allow_glob_domains, and allow_any_name allows everything. Returns
+// an error if the role doesn't exist.
+func RoleAllowsDomain(m MountReaderWriter, mountPath, roleName, domain string) (bool, error) {
+	client := m.Client()
+	readPath := fmt.Sprintf("%s/roles/%s", mountPath, roleName)
+	secret, err := m.Read(client, readPath)
+	if err != nil {
+		return false, err
+	}
+	if secret == nil || secret.Data == nil {
+		return false, fmt.Errorf("role %s was not found", roleName)
+	}
+	flags := map[string]bool{}
+	for _, k := range []string{"allow_any_name", "allow_bare_domains", "allow_subdomains", "allow_glob_domains"} {
+		v, ok := secret.Data[k]
+		if !ok || v == nil {
+			continue
+		}
+		if flags[k], err = dataBool(v); err != nil {
+			return false, err
+		}
+	}
+	if flags["allow_any_name"] {
+		return true, nil
+	}
+	var allowed []string
+	if v, ok := secret.Data["allowed_domains"]; ok && v != nil {
+		if allowed, err = dataStrings(v); err != nil {
+			return false, err
+		}
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if domain == a && flags["allow_bare_domains"] {
+			return true, nil
+		}
+		if flags["allow_subdomains"] && strings.HasSuffix(domain, "."+a) {
+			return true, nil
+		}
+		if flags["allow_glob_domains"] && strings.Contains(a, "*") {
+			if matched, _ := path.Match(a, domain); matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// dataStrings converts a value from a secret's Data into a list of strings.
+// Depending on the version, Vault returns lists as either JSON lists or
+// comma-separated strings.
+func dataStrings(v interface{}) ([]string, error) {
+	switch l := v.(type) {
+	case string:
+		return splitCSV(l), nil
+	case []string:
+		return l, nil
+	case []interface{}:
+		strs := make([]string, 0, len(l))
+		for _, i := range l {
+			s, ok := i.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v is not a string", i)
+			}
+			strs = append(strs, s)
+		}
+		return strs, nil
+	default:
+		return nil, fmt.Errorf("%v is not a list of strings", v)
+	}
+}
+
+// splitCSV splits a comma-separated string, trimming whitespace and dropping
+// empty values.
+func splitCSV(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// sameStringSet returns true if a and b contain the same strings, ignoring
+// order and duplicates.
+func sameStringSet(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, s := range a {
+		set[s] = true
+	}
+	other := make(map[string]bool, len(b))
+	for _, s := range b {
+		if !set[s] {
+			return false
+		}
+		other[s] = true
+	}
+	return len(set) == len(other)
+}
+
+// dataBool converts a value from a secret's Data into a bool. Depending on the
+// version, Vault returns booleans as either JSON booleans or strings.
+func dataBool(v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		return strconv.ParseBool(b)
+	default:
+		return false, fmt.Errorf("%v is not a boolean", v)
+	}
+}
+
+// HasRoles reports which of the passed in roles exist in the backend mounted
+// at mountPath. Unlike HasRole, the roles' settings aren't compared. If the
+// MountReaderWriter is also a PathLister, the roles are listed once rather than
+// read one at a time.
+func HasRoles(m MountReaderWriter, mountPath string, roles []string) (map[string]bool, error) {
+	client := m.Client()
+	found := make(map[string]bool, len(roles))
+	if l, ok := m.(PathLister); ok {
+		secret, err := l.List(client, fmt.Sprintf("%s/roles", mountPath))
+		if err != nil {
+			return nil, err
+		}
+		keys, err := secretKeys(secret)
+		if err != nil {
+			return nil, err
+		}
+		existing := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			existing[k] = true
+		}
+		for _, role := range roles {
+			found[role] = existing[role]
+		}
+		return found, nil
+	}
+	for _, role := range roles {
+		secret, err := m.Read(client, fmt.Sprintf("%s/roles/%s", mountPath, role))
+		if err != nil {
+			return nil, err
+		}
+		found[role] = secret != nil && secret.Data != nil
+	}
+	return found, nil
+}
+
+// RoleExporter is an interface for objects that can list and read the roles in
+// a pki backend.
+type RoleExporter interface {
+	ClientGetter
+	PathLister
+	MountReader
+}
+
+// ExportRoles reads the settings of every role in the pki backend mounted at
+// mountPath, keyed by role name, so that they can be backed up and recreated
+// with CreateRoleWithConfig. Roles that can't be read or parsed are left out,
+// and their errors are returned joined together along with the roles that were
+// exported. Returns an error and no roles if the roles can't be listed.
+func ExportRoles(m RoleExporter, mountPath string) (map[string]*RoleConfig, error) {
+	names, err := ListPath(m, fmt.Sprintf("%s/roles", mountPath))
+	if err != nil {
+		return nil, err
+	}
+	client := m.Client()
+	roles := make(map[string]*RoleConfig, len(names))
+	var errs []error
+	for _, name := range names {
+		secret, err := m.Read(client, fmt.Sprintf("%s/roles/%s", mountPath, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("role %s: %w", name, err))
+			continue
+		}
+		if secret == nil || secret.Data == nil {
+			errs = append(errs, fmt.Errorf("role %s was not found", name))
+			continue
+		}
+		c, err := parseRoleConfig(secret.Data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("role %s: %w", name, err))
+			continue
+		}
+		roles[name] = c
+	}
+	return roles, errors.Join(errs...)
+}
+
+// parseRoleConfig converts the data read from a role into a RoleConfig. TTLs,
+// which Vault reports in seconds, are converted with SecondsToTTL. Settings
+// that are missing from the data are left unset.
+func parseRoleConfig(data map[string]interface{}) (*RoleConfig, error) {
+	c := &RoleConfig{}
+	present := func(k string) (interface{}, bool) {
+		v, ok := data[k]
+		return v, ok && v != nil
+	}
+	if v, ok := present("key_type"); ok {
+		keyType, isString := v.(string)
+		if !isString {
+			return nil, fmt.Errorf("key_type: %v is not a string", v)
+		}
+		c.KeyType = keyType
+	}
+	if v, ok := present("key_bits"); ok {
+		bits, err := dataInt(v)
+		if err != nil {
+			return nil, fmt.Errorf("key_bits: %w", err)
+		}
+		c.KeyBits = bits
+	}
+	csvs := map[string]*string{
+		"allowed_domains":  &c.AllowedDomains,
+		"allowed_uri_sans": &c.AllowedURISans,
+	}
+	for k, p := range csvs {
+		if v, ok := present(k); ok {
+			strs, err := dataStrings(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			*p = strings.Join(strs, ",")
+		}
+	}
+	ttls := map[string]*string{
+		"ttl":                 &c.TTL,
+		"max_ttl":             &c.MaxTTL,
+		"not_before_duration": &c.NotBeforeDuration,
+	}
+	for k, p := range ttls {
+		if v, ok := present(k); ok {
+			secs, err := dataInt(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			*p = SecondsToTTL(secs)
+		}
+	}
+	flags := map[string]*bool{
+		"allow_subdomains":   &c.AllowSubdomains,
+		"allow_any_name":     &c.AllowAnyName,
+		"allow_bare_domains": &c.AllowBareDomains,
+	}
+	for k, p := range flags {
+		if v, ok := present(k); ok {
+			b, err := dataBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			*p = b
+		}
+	}
+	optionalFlags := map[string]**bool{
+		"allow_localhost": &c.AllowLocalhost,
+		"allow_ip_sans":   &c.AllowIPSans,
+		"server_flag":     &c.ServerFlag,
+		"client_flag":     &c.ClientFlag,
+	}
+	for k, p := range optionalFlags {
+		if v, ok := present(k); ok {
+			b, err := dataBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			*p = &b
+		}
+	}
+	lists := map[string]*[]string{
+		"organization":           &c.Organization,
+		"ou":                     &c.OU,
+		"country":                &c.Country,
+		"locality":               &c.Locality,
+		"province":               &c.Province,
+		"street_address":         &c.StreetAddress,
+		"postal_code":            &c.PostalCode,
+		"key_usage":              &c.KeyUsage,
+		"ext_key_usage":          &c.ExtKeyUsage,
+		"allowed_serial_numbers": &c.AllowedSerialNumbers,
+		"allowed_user_ids":       &c.AllowedUserIDs,
+	}
+	for k, p := range lists {
+		if v, ok := present(k); ok {
+			strs, err := dataStrings(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if len(strs) > 0 {
+				*p = strs
+			}
+		}
+	}
+	return c, nil
+}
+
+// RoleRule maps common names ending in a domain suffix to a role.
+type RoleRule struct {
+	Suffix string // e.g. "cluster-a.example.com". Matches the domain itself and its subdomains.
+	Role   string
+}
+
+// RoleResolver picks the role to issue a cert with based on its common name.
+type RoleResolver struct {
+	Rules []RoleRule
+}
+
+// Resolve returns the role for the common name. When more than one rule
+// matches, the one with the longest suffix wins. Returns an error if no rule
+// matches.
+func (r *RoleResolver) Resolve(commonName string) (string, error) {
+	cn := strings.ToLower(strings.TrimSuffix(commonName, "."))
+	var (
+		role string
+		best = -1
+	)
+	for _, rule := range r.Rules {
+		suffix := strings.ToLower(strings.Trim(rule.Suffix, "."))
+		if cn != suffix && !strings.HasSuffix(cn, "."+suffix) {
+			continue
+		}
+		if len(suffix) > best {
+			role, best = rule.Role, len(suffix)
+		}
+	}
+	if best < 0 {
+		return "", fmt.Errorf("no role matches common name %s", commonName)
 	}
-	return true, nil
+	return role, nil
 }
@@ -1,7 +1,10 @@
 package vaulter
 
 import (
+	"encoding/json"
 	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
 	vault "github.com/hashicorp/vault/api"
@@ -18,7 +21,8 @@ func (r *StubRoller) Client() *vault.Client {
 	return &vault.Client{}
 }
 
-func (r *StubRoller) Write(client *vault.Client, token string, data map[string]interface{}) (*vault.Secret, error) {
+func (r *StubRoller) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	r.path = path
 	r.data = data
 	secret := &vault.Secret{}
 	if r.writeError {
@@ -55,6 +59,19 @@ func TestCreateRole(t *testing.T) {
 		t.Error("secret is nil")
 	}
 
+	if sr.data["allowed_uri_sans"] != "" {
+		t.Errorf("allowed_uri_sans was '%s' instead of ''", sr.data["allowed_uri_sans"])
+	}
+
+	sr = &StubRoller{}
+	rc.AllowedURISans = "spiffe://example.com/*"
+	if _, err = CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["allowed_uri_sans"] != "spiffe://example.com/*" {
+		t.Errorf("allowed_uri_sans was '%s' instead of 'spiffe://example.com/*'", sr.data["allowed_uri_sans"])
+	}
+
 	sr = &StubRoller{writeError: true}
 	secret, err = CreateRole(sr, "pki", "foo", rc)
 	if err == nil {
@@ -74,4 +91,795 @@ func TestHasRole(t *testing.T) {
 	if !hasRole {
 		t.Error("hasRole was false")
 	}
+
+	if hasRole, err = HasRole(sr, "pki", "foo", "foo.com", false); err != nil {
+		t.Error(err)
+	}
+	if hasRole {
+		t.Error("hasRole was true when allow_subdomains didn't match")
+	}
+
+	// Older versions of Vault return allow_subdomains as a string.
+	rw := &StubMountReaderWriter{}
+	if hasRole, err = HasRole(rw, "pki", "foo", "foo.com", true); err != nil {
+		t.Error(err)
+	}
+	if !hasRole {
+		t.Error("hasRole was false for a string allow_subdomains")
+	}
+	if hasRole, err = HasRole(rw, "pki", "foo", "foo.com", false); err != nil {
+		t.Error(err)
+	}
+	if hasRole {
+		t.Error("hasRole was true when a string allow_subdomains didn't match")
+	}
+}
+
+func TestDataBool(t *testing.T) {
+	for _, v := range []interface{}{true, "true", "1"} {
+		b, err := dataBool(v)
+		if err != nil {
+			t.Error(err)
+		}
+		if !b {
+			t.Errorf("%v converted to false", v)
+		}
+	}
+	for _, v := range []interface{}{false, "false", "0"} {
+		b, err := dataBool(v)
+		if err != nil {
+			t.Error(err)
+		}
+		if b {
+			t.Errorf("%v converted to true", v)
+		}
+	}
+	for _, v := range []interface{}{"yes please", 1, nil} {
+		if _, err := dataBool(v); err == nil {
+			t.Errorf("err was nil for %v", v)
+		}
+	}
+}
+
+func TestCreateRoleKeyUsage(t *testing.T) {
+	sr := &StubRoller{}
+	rc := &RoleConfig{
+		AllowedDomains: "foo.com",
+		KeyUsage:       []string{"DigitalSignature"},
+		ExtKeyUsage:    []string{"ClientAuth"},
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	ku, ok := sr.data["key_usage"].([]string)
+	if !ok || len(ku) != 1 || ku[0] != "DigitalSignature" {
+		t.Errorf("key_usage was %v instead of [DigitalSignature]", sr.data["key_usage"])
+	}
+	eku, ok := sr.data["ext_key_usage"].([]string)
+	if !ok || len(eku) != 1 || eku[0] != "ClientAuth" {
+		t.Errorf("ext_key_usage was %v instead of [ClientAuth]", sr.data["ext_key_usage"])
+	}
+
+	sr = &StubRoller{}
+	rc = &RoleConfig{AllowedDomains: "foo.com"}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if _, ok = sr.data["key_usage"]; ok {
+		t.Error("key_usage was set when it wasn't configured")
+	}
+	if _, ok = sr.data["ext_key_usage"]; ok {
+		t.Error("ext_key_usage was set when it wasn't configured")
+	}
+}
+
+func TestCreateRoleLocalhostAndBareDomains(t *testing.T) {
+	sr := &StubRoller{}
+	allowLocalhost := true
+	rc := &RoleConfig{
+		AllowedDomains:   "foo.com",
+		AllowLocalhost:   &allowLocalhost,
+		AllowBareDomains: true,
+	}
+	if _, err := CreateRole(sr, "pki", "dev", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["allow_localhost"] != "true" {
+		t.Errorf("allow_localhost was %v instead of true", sr.data["allow_localhost"])
+	}
+	if sr.data["allow_bare_domains"] != "true" {
+		t.Errorf("allow_bare_domains was %v instead of true", sr.data["allow_bare_domains"])
+	}
+
+	allowLocalhost = false
+	rc.AllowBareDomains = false
+	if _, err := CreateRole(sr, "pki", "dev", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["allow_localhost"] != "false" {
+		t.Errorf("allow_localhost was %v instead of false", sr.data["allow_localhost"])
+	}
+	if sr.data["allow_bare_domains"] != "false" {
+		t.Errorf("allow_bare_domains was %v instead of false", sr.data["allow_bare_domains"])
+	}
+
+	rc.AllowLocalhost = nil
+	if _, err := CreateRole(sr, "pki", "dev", rc); err != nil {
+		t.Error(err)
+	}
+	if _, ok := sr.data["allow_localhost"]; ok {
+		t.Error("allow_localhost was written when it wasn't set")
+	}
+}
+
+func TestCreateRoleSubject(t *testing.T) {
+	sr := &StubRoller{}
+	rc := &RoleConfig{
+		AllowedDomains: "foo.com",
+		Organization:   []string{"CyVerse"},
+		OU:             []string{"Discovery Environment"},
+		Country:        []string{"US"},
+		Province:       []string{"Arizona"},
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	fields := map[string]string{
+		"organization": "CyVerse",
+		"ou":           "Discovery Environment",
+		"country":      "US",
+		"province":     "Arizona",
+	}
+	for k, expected := range fields {
+		actual, ok := sr.data[k].([]string)
+		if !ok || len(actual) != 1 || actual[0] != expected {
+			t.Errorf("sr.data[\"%s\"] => %v, expected => [%s]", k, sr.data[k], expected)
+		}
+	}
+	for _, k := range []string{"locality", "street_address", "postal_code"} {
+		if _, ok := sr.data[k]; ok {
+			t.Errorf("%s was written when it wasn't set", k)
+		}
+	}
+}
+
+func TestCreateRoleNotBeforeDuration(t *testing.T) {
+	sr := &StubRoller{}
+	rc := &RoleConfig{
+		AllowedDomains:    "foo.com",
+		NotBeforeDuration: "30s",
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["not_before_duration"] != "30s" {
+		t.Errorf("not_before_duration was %v instead of 30s", sr.data["not_before_duration"])
+	}
+
+	rc.NotBeforeDuration = ""
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if _, ok := sr.data["not_before_duration"]; ok {
+		t.Error("not_before_duration was written when it wasn't set")
+	}
+}
+
+type StubDefaultingRoller struct {
+	StubRoller
+	defaults *RoleDefaults
+}
+
+func (r *StubDefaultingRoller) RoleDefaults() *RoleDefaults {
+	return r.defaults
+}
+
+func TestCreateRoleDefaults(t *testing.T) {
+	sr := &StubDefaultingRoller{
+		defaults: &RoleDefaults{
+			Role:            "htcondor",
+			AllowedDomains:  "example.com",
+			AllowSubdomains: true,
+			MaxTTL:          "720h",
+		},
+	}
+	rc := &RoleConfig{}
+	if _, err := CreateRole(sr, "pki", "htcondor", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["allowed_domains"] != "example.com" {
+		t.Errorf("allowed_domains was %v instead of example.com", sr.data["allowed_domains"])
+	}
+	if sr.data["allow_subdomains"] != "true" {
+		t.Errorf("allow_subdomains was %v instead of true", sr.data["allow_subdomains"])
+	}
+	if sr.data["max_ttl"] != "720h" {
+		t.Errorf("max_ttl was %v instead of 720h", sr.data["max_ttl"])
+	}
+	if rc.AllowedDomains != "" || rc.MaxTTL != "" {
+		t.Error("the caller's RoleConfig was modified")
+	}
+
+	rc = &RoleConfig{
+		AllowedDomains: "foo.com",
+		MaxTTL:         "24h",
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if sr.data["allowed_domains"] != "foo.com" {
+		t.Errorf("allowed_domains was %v instead of foo.com", sr.data["allowed_domains"])
+	}
+	if sr.data["allow_subdomains"] != "false" {
+		t.Errorf("allow_subdomains was %v instead of false", sr.data["allow_subdomains"])
+	}
+	if sr.data["max_ttl"] != "24h" {
+		t.Errorf("max_ttl was %v instead of 24h", sr.data["max_ttl"])
+	}
+}
+
+func TestCreateRoleDefaultsWithoutDomains(t *testing.T) {
+	sr := &StubDefaultingRoller{defaults: &RoleDefaults{Role: "r"}}
+	rc := &RoleConfig{AllowAnyName: true, AllowSubdomains: true}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Fatal(err)
+	}
+	if sr.data["allow_subdomains"] != "true" {
+		t.Errorf("allow_subdomains was %v instead of true", sr.data["allow_subdomains"])
+	}
+	if sr.data["allow_any_name"] != "true" {
+		t.Errorf("allow_any_name was %v instead of true", sr.data["allow_any_name"])
+	}
+}
+
+func TestCreateRoleAllowedSerialNumbers(t *testing.T) {
+	sr := &StubRoller{}
+	rc := &RoleConfig{
+		AllowedDomains:       "foo.com",
+		AllowedSerialNumbers: []string{"node-0001", "node-0002"},
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	sn, ok := sr.data["allowed_serial_numbers"].([]string)
+	if !ok || len(sn) != 2 || sn[0] != "node-0001" || sn[1] != "node-0002" {
+		t.Errorf("allowed_serial_numbers was %v instead of [node-0001 node-0002]", sr.data["allowed_serial_numbers"])
+	}
+
+	sr = &StubRoller{}
+	rc = &RoleConfig{AllowedDomains: "foo.com"}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	if _, ok = sr.data["allowed_serial_numbers"]; ok {
+		t.Error("allowed_serial_numbers was set when it wasn't configured")
+	}
+}
+
+type StubRoleReader struct {
+	StubRoller
+	roles []string
+	reads int
+}
+
+func (r *StubRoleReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	if r.readError {
+		return nil, errors.New("read error")
+	}
+	r.reads++
+	for _, role := range r.roles {
+		if path == "pki/roles/"+role {
+			return &vault.Secret{
+				Data: map[string]interface{}{
+					"allowed_domains":  "foo.com",
+					"allow_subdomains": true,
+				},
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+type StubRoleListReader struct {
+	StubRoleReader
+	listPath  string
+	lists     int
+	listError bool
+}
+
+func (r *StubRoleListReader) List(client *vault.Client, path string) (*vault.Secret, error) {
+	r.listPath = path
+	r.lists++
+	if r.listError {
+		return nil, errors.New("list error")
+	}
+	keys := make([]interface{}, len(r.roles))
+	for i, role := range r.roles {
+		keys[i] = role
+	}
+	return &vault.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func TestHasRoles(t *testing.T) {
+	roles := []string{"htcondor", "irods", "missing"}
+	existing := []string{"htcondor", "irods", "other"}
+
+	lr := &StubRoleListReader{StubRoleReader: StubRoleReader{roles: existing}}
+	batch, err := HasRoles(lr, "pki", roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lr.listPath != "pki/roles" {
+		t.Errorf("list path was '%s' instead of 'pki/roles'", lr.listPath)
+	}
+	if lr.lists != 1 || lr.reads != 0 {
+		t.Errorf("there were %d lists and %d reads instead of 1 list and no reads", lr.lists, lr.reads)
+	}
+
+	rr := &StubRoleReader{roles: existing}
+	individual, err := HasRoles(rr, "pki", roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.reads != len(roles) {
+		t.Errorf("there were %d reads instead of %d", rr.reads, len(roles))
+	}
+
+	for _, role := range roles {
+		hasRole, err := HasRole(rr, "pki", role, "foo.com", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch[role] != hasRole {
+			t.Errorf("the batch result for %s was %t while HasRole returned %t", role, batch[role], hasRole)
+		}
+		if individual[role] != hasRole {
+			t.Errorf("the individual result for %s was %t while HasRole returned %t", role, individual[role], hasRole)
+		}
+	}
+	if len(batch) != len(roles) {
+		t.Errorf("the batch result had %d entries instead of %d", len(batch), len(roles))
+	}
+
+	lr.listError = true
+	if _, err = HasRoles(lr, "pki", roles); err == nil {
+		t.Error("err was nil for a list error")
+	}
+	rr.readError = true
+	if _, err = HasRoles(rr, "pki", roles); err == nil {
+		t.Error("err was nil for a read error")
+	}
+}
+
+func TestRoleResolver(t *testing.T) {
+	r := &RoleResolver{
+		Rules: []RoleRule{
+			{Suffix: "example.com", Role: "default-role"},
+			{Suffix: "cluster-a.example.com", Role: "cluster-a-role"},
+			{Suffix: ".cluster-b.example.com", Role: "cluster-b-role"},
+		},
+	}
+	cases := map[string]string{
+		"node1.cluster-a.example.com":  "cluster-a-role",
+		"cluster-a.example.com":        "cluster-a-role",
+		"NODE2.Cluster-B.example.com.": "cluster-b-role",
+		"www.example.com":              "default-role",
+		"notcluster-a.example.com":     "default-role",
+	}
+	for cn, expected := range cases {
+		role, err := r.Resolve(cn)
+		if err != nil {
+			t.Errorf("%s: %s", cn, err)
+			continue
+		}
+		if role != expected {
+			t.Errorf("%s resolved to '%s' instead of '%s'", cn, role, expected)
+		}
+	}
+
+	for _, cn := range []string{"example.org", "badexample.com", ""} {
+		if _, err := r.Resolve(cn); err == nil {
+			t.Errorf("err was nil for %q", cn)
+		}
+	}
+}
+
+func TestCreateRoleAllowedUserIDs(t *testing.T) {
+	sr := &StubRoller{}
+	rc := &RoleConfig{
+		AllowedDomains: "foo.com",
+		AllowedUserIDs: []string{"job-*"},
+	}
+	if _, err := CreateRole(sr, "pki", "foo", rc); err != nil {
+		t.Error(err)
+	}
+	ids, ok := sr.data["allowed_user_ids"].([]string)
+	if !ok || len(ids) != 1 || ids[0] != "job-*" {
+		t.Errorf("allowed_user_ids was %v instead of [job-*]", sr.data["allowed_user_ids"])
+	}
+
+	sr = &StubRoller{}
+	if _, err := CreateRole(sr, "pki", "foo", &RoleConfig{AllowedDomains: "foo.com"}); err != nil {
+		t.Error(err)
+	}
+	if _, ok = sr.data["allowed_user_ids"]; ok {
+		t.Error("allowed_user_ids was set when it wasn't configured")
+	}
+}
+
+func TestRoleMountPaths(t *testing.T) {
+	sr := &StubRoller{}
+	if _, err := CreateRole(sr, "pki-cluster-a", "foo", &RoleConfig{AllowedDomains: "foo.com"}); err != nil {
+		t.Error(err)
+	}
+	if sr.path != "pki-cluster-a/roles/foo" {
+		t.Errorf("CreateRole path was '%s' instead of 'pki-cluster-a/roles/foo'", sr.path)
+	}
+	if _, err := HasRole(sr, "pki-cluster-b", "foo", "foo.com", true); err != nil {
+		t.Error(err)
+	}
+	if sr.path != "pki-cluster-b/roles/foo" {
+		t.Errorf("HasRole path was '%s' instead of 'pki-cluster-b/roles/foo'", sr.path)
+	}
+}
+
+func TestCreateRoleWithConfig(t *testing.T) {
+	sr := &StubRoller{}
+	serverFlag := true
+	clientFlag := false
+	allowIPSans := false
+	cfg := RoleConfig{
+		AllowedDomains:  "htcondor.example.com",
+		AllowSubdomains: true,
+		TTL:             "72h",
+		MaxTTL:          "720h",
+		KeyType:         "ec",
+		KeyBits:         256,
+		AllowIPSans:     &allowIPSans,
+		ServerFlag:      &serverFlag,
+		ClientFlag:      &clientFlag,
+	}
+	if _, err := CreateRoleWithConfig(sr, "pki", "htcondor", cfg); err != nil {
+		t.Error(err)
+	}
+	if sr.path != "pki/roles/htcondor" {
+		t.Errorf("path was '%s' instead of 'pki/roles/htcondor'", sr.path)
+	}
+	expected := map[string]interface{}{
+		"allowed_domains":  "htcondor.example.com",
+		"allow_subdomains": "true",
+		"ttl":              "72h",
+		"max_ttl":          "720h",
+		"key_type":         "ec",
+		"key_bits":         256,
+		"allow_ip_sans":    "false",
+		"server_flag":      "true",
+		"client_flag":      "false",
+	}
+	if len(sr.data) != len(expected) {
+		t.Errorf("data had %d keys instead of %d: %v", len(sr.data), len(expected), sr.data)
+	}
+	for k, v := range expected {
+		if sr.data[k] != v {
+			t.Errorf("%s was %v instead of %v", k, sr.data[k], v)
+		}
+	}
+
+	sr = &StubRoller{}
+	if _, err := CreateRoleWithConfig(sr, "pki", "empty", RoleConfig{}); err != nil {
+		t.Error(err)
+	}
+	if len(sr.data) != 1 || sr.data["key_type"] != "rsa" {
+		t.Errorf("data was %v instead of only the default key_type", sr.data)
+	}
+
+	sr = &StubRoller{}
+	if _, err := CreateRole(sr, "pki", "foo", &RoleConfig{}); err != nil {
+		t.Error(err)
+	}
+	for _, k := range []string{"allowed_domains", "allow_subdomains", "key_bits", "allow_any_name", "allowed_uri_sans", "allow_bare_domains"} {
+		if _, ok := sr.data[k]; !ok {
+			t.Errorf("CreateRole didn't write %s", k)
+		}
+	}
+	if sr.data["key_type"] != "rsa" {
+		t.Errorf("key_type was %v instead of the default of rsa", sr.data["key_type"])
+	}
+	for _, k := range []string{"ttl", "allow_ip_sans", "server_flag", "client_flag"} {
+		if _, ok := sr.data[k]; ok {
+			t.Errorf("CreateRole wrote %s when it wasn't configured", k)
+		}
+	}
+
+	sr = &StubRoller{writeError: true}
+	if _, err := CreateRoleWithConfig(sr, "pki", "foo", cfg); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
+
+func TestCreateRoleKeyType(t *testing.T) {
+	valid := []RoleConfig{
+		{KeyType: "rsa", KeyBits: 2048},
+		{KeyType: "ec", KeyBits: 256},
+		{KeyType: "any"},
+	}
+	for _, cfg := range valid {
+		sr := &StubRoller{}
+		if _, err := CreateRoleWithConfig(sr, "pki", "htcondor", cfg); err != nil {
+			t.Errorf("key_type %q was rejected: %s", cfg.KeyType, err)
+		}
+		if sr.data["key_type"] != cfg.KeyType {
+			t.Errorf("key_type was %v instead of %s", sr.data["key_type"], cfg.KeyType)
+		}
+	}
+
+	invalid := []RoleConfig{
+		{KeyType: "dsa"},
+		{KeyType: "ec", KeyBits: 2048},
+	}
+	for _, cfg := range invalid {
+		sr := &StubRoller{}
+		if _, err := CreateRole(sr, "pki", "htcondor", &cfg); err == nil {
+			t.Errorf("key_type %q with key_bits %d was accepted", cfg.KeyType, cfg.KeyBits)
+		}
+		if sr.data != nil {
+			t.Errorf("the role was written for key_type %q with key_bits %d", cfg.KeyType, cfg.KeyBits)
+		}
+	}
+}
+
+type StubDomainListRoller struct {
+	StubRoller
+	domains interface{}
+}
+
+func (r *StubDomainListRoller) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	r.path = path
+	return &vault.Secret{
+		Data: map[string]interface{}{
+			"allowed_domains":  r.domains,
+			"allow_subdomains": true,
+		},
+	}, nil
+}
+
+func TestHasRoleDomainList(t *testing.T) {
+	sr := &StubDomainListRoller{domains: []interface{}{"foo.com"}}
+	hasRole, err := HasRole(sr, "pki", "foo", "foo.com", true)
+	if err != nil {
+		t.Error(err)
+	}
+	if !hasRole {
+		t.Error("hasRole was false for a list-valued allowed_domains")
+	}
+
+	sr.domains = []interface{}{"bar.com", "foo.com"}
+	if hasRole, err = HasRole(sr, "pki", "foo", "foo.com, bar.com", true); err != nil {
+		t.Error(err)
+	}
+	if !hasRole {
+		t.Error("hasRole was false for the same domains in a different order")
+	}
+	if hasRole, err = HasRole(sr, "pki", "foo", "foo.com", true); err != nil {
+		t.Error(err)
+	}
+	if hasRole {
+		t.Error("hasRole was true when a domain was missing")
+	}
+
+	sr.domains = "bar.com,foo.com"
+	if hasRole, err = HasRole(sr, "pki", "foo", "foo.com,bar.com", true); err != nil {
+		t.Error(err)
+	}
+	if !hasRole {
+		t.Error("hasRole was false for a comma-separated allowed_domains")
+	}
+
+	sr.domains = []interface{}{"foo.com", 1}
+	if _, err = HasRole(sr, "pki", "foo", "foo.com", true); err == nil {
+		t.Error("err was nil for a non-string domain")
+	}
+}
+
+type StubRoleDataReader struct {
+	StubRoller
+	role map[string]interface{}
+}
+
+func (r *StubRoleDataReader) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	r.path = path
+	if r.readError {
+		return nil, errors.New("read error")
+	}
+	if r.role == nil {
+		return nil, nil
+	}
+	return &vault.Secret{Data: r.role}, nil
+}
+
+func TestRoleAllowsDomain(t *testing.T) {
+	r := &StubRoleDataReader{
+		role: map[string]interface{}{
+			"allowed_domains":    []interface{}{"example.com", "cluster-*.example.org"},
+			"allow_bare_domains": true,
+			"allow_subdomains":   true,
+			"allow_glob_domains": "true",
+		},
+	}
+	cases := map[string]bool{
+		"example.com":                true,
+		"EXAMPLE.com.":               true,
+		"foo.example.com":            true,
+		"a.b.example.com":            true,
+		"*.example.com":              true,
+		"cluster-a.example.org":      true,
+		"notexample.com":             false,
+		"example.org":                false,
+		"foo.cluster-a.example.org":  false,
+		"example.com.attacker.local": false,
+	}
+	for domain, expected := range cases {
+		allowed, err := RoleAllowsDomain(r, "pki", "foo", domain)
+		if err != nil {
+			t.Errorf("%s: %s", domain, err)
+			continue
+		}
+		if allowed != expected {
+			t.Errorf("%s was allowed: %t instead of %t", domain, allowed, expected)
+		}
+	}
+	if r.path != "pki/roles/foo" {
+		t.Errorf("path was '%s' instead of 'pki/roles/foo'", r.path)
+	}
+
+	r.role = map[string]interface{}{
+		"allowed_domains": "example.com",
+	}
+	for domain, expected := range map[string]bool{"example.com": false, "foo.example.com": false} {
+		allowed, err := RoleAllowsDomain(r, "pki", "foo", domain)
+		if err != nil {
+			t.Error(err)
+		}
+		if allowed != expected {
+			t.Errorf("%s was allowed without bare domains or subdomains", domain)
+		}
+	}
+
+	r.role = map[string]interface{}{"allow_any_name": true}
+	if allowed, err := RoleAllowsDomain(r, "pki", "foo", "anything.test"); err != nil || !allowed {
+		t.Errorf("allow_any_name didn't allow the domain: %t, %v", allowed, err)
+	}
+
+	r.role = nil
+	if _, err := RoleAllowsDomain(r, "pki", "foo", "example.com"); err == nil {
+		t.Error("err was nil for a missing role")
+	}
+	r.readError = true
+	if _, err := RoleAllowsDomain(r, "pki", "foo", "example.com"); err == nil {
+		t.Error("err was nil for a read error")
+	}
+}
+
+type StubRoleExporter struct {
+	roles      map[string]map[string]interface{}
+	readErrors map[string]bool
+	listPath   string
+	listError  bool
+}
+
+func (r *StubRoleExporter) Client() *vault.Client {
+	return &vault.Client{}
+}
+
+func (r *StubRoleExporter) List(client *vault.Client, path string) (*vault.Secret, error) {
+	r.listPath = path
+	if r.listError {
+		return nil, errors.New("list error")
+	}
+	var keys []interface{}
+	for name := range r.roles {
+		keys = append(keys, name)
+	}
+	for name := range r.readErrors {
+		keys = append(keys, name)
+	}
+	return &vault.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func (r *StubRoleExporter) Read(client *vault.Client, path string) (*vault.Secret, error) {
+	name := strings.TrimPrefix(path, "pki/roles/")
+	if r.readErrors[name] {
+		return nil, errors.New("read error")
+	}
+	data, ok := r.roles[name]
+	if !ok {
+		return nil, nil
+	}
+	return &vault.Secret{Data: data}, nil
+}
+
+func TestExportRoles(t *testing.T) {
+	r := &StubRoleExporter{
+		roles: map[string]map[string]interface{}{
+			"htcondor": {
+				"allowed_domains":     []interface{}{"example.com", "example.org"},
+				"allow_subdomains":    true,
+				"allow_bare_domains":  "false",
+				"allow_localhost":     false,
+				"key_type":            "ec",
+				"key_bits":            json.Number("256"),
+				"ttl":                 json.Number("3600"),
+				"max_ttl":             json.Number("86400"),
+				"not_before_duration": json.Number("30"),
+				"ext_key_usage":       []interface{}{"ClientAuth", "ServerAuth"},
+				"organization":        []interface{}{},
+			},
+			"irods": {
+				"allowed_domains": "irods.example.com",
+				"allow_any_name":  "true",
+				"key_type":        "rsa",
+				"key_bits":        json.Number("2048"),
+				"max_ttl":         json.Number("0"),
+			},
+		},
+	}
+	roles, err := ExportRoles(r, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.listPath != "pki/roles" {
+		t.Errorf("list path was '%s' instead of 'pki/roles'", r.listPath)
+	}
+	if len(roles) != 2 {
+		t.Fatalf("%d roles were exported instead of 2", len(roles))
+	}
+
+	allowLocalhost := false
+	expected := &RoleConfig{
+		AllowedDomains:    "example.com,example.org",
+		AllowSubdomains:   true,
+		AllowLocalhost:    &allowLocalhost,
+		KeyType:           "ec",
+		KeyBits:           256,
+		TTL:               "1h0m0s",
+		MaxTTL:            "24h0m0s",
+		NotBeforeDuration: "30s",
+		ExtKeyUsage:       []string{"ClientAuth", "ServerAuth"},
+	}
+	if !reflect.DeepEqual(roles["htcondor"], expected) {
+		t.Errorf("the htcondor role was %+v instead of %+v", roles["htcondor"], expected)
+	}
+	expected = &RoleConfig{
+		AllowedDomains: "irods.example.com",
+		AllowAnyName:   true,
+		KeyType:        "rsa",
+		KeyBits:        2048,
+	}
+	if !reflect.DeepEqual(roles["irods"], expected) {
+		t.Errorf("the irods role was %+v instead of %+v", roles["irods"], expected)
+	}
+
+	// The roles that can be exported are returned along with the errors.
+	r.roles["bogus"] = map[string]interface{}{"key_bits": "lots"}
+	r.readErrors = map[string]bool{"unreadable": true}
+	roles, err = ExportRoles(r, "pki")
+	if err == nil {
+		t.Fatal("err was nil for roles that couldn't be exported")
+	}
+	for _, name := range []string{"bogus", "unreadable"} {
+		if !strings.Contains(err.Error(), "role "+name) {
+			t.Errorf("the error '%s' didn't mention the %s role", err, name)
+		}
+		if _, ok := roles[name]; ok {
+			t.Errorf("the %s role was exported", name)
+		}
+	}
+	if len(roles) != 2 || roles["htcondor"] == nil || roles["irods"] == nil {
+		t.Errorf("roles were %v instead of htcondor and irods", roles)
+	}
+
+	r.listError = true
+	if _, err = ExportRoles(r, "pki"); err == nil {
+		t.Error("err was nil for a list error")
+	}
 }
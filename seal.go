@@ -0,0 +1,53 @@
+package vaulter
+
+import (
+	"errors"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// SealStatusGetter is an interface for objects that can get the seal status of
+// the Vault server.
+type SealStatusGetter interface {
+	SealStatus() (*vault.SealStatusResponse, error)
+}
+
+// Sealer is an interface for objects that can report whether the Vault server
+// is sealed. It's the same interface as SealStatusGetter.
+type Sealer = SealStatusGetter
+
+// SealStatus returns the seal status of the Vault server.
+func SealStatus(s Sealer) (*vault.SealStatusResponse, error) {
+	return s.SealStatus()
+}
+
+// IsSealed returns true if the Vault server is sealed.
+func IsSealed(s Sealer) (bool, error) {
+	status, err := s.SealStatus()
+	if err != nil {
+		return false, err
+	}
+	if status == nil {
+		return false, errors.New("no seal status was returned")
+	}
+	return status.Sealed, nil
+}
+
+// RecoverySealStatus returns the seal status of a Vault server that uses
+// auto-unseal, where recovery keys take the place of unseal key shares. The
+// RecoverySeal, RecoverySealType, and Migration fields of the response report
+// the recovery seal and any seal migration in progress. Returns an error if the
+// server doesn't use a recovery seal.
+func RecoverySealStatus(s SealStatusGetter) (*vault.SealStatusResponse, error) {
+	status, err := s.SealStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, errors.New("no seal status was returned")
+	}
+	if !status.RecoverySeal {
+		return nil, errors.New("the vault server does not use a recovery seal")
+	}
+	return status, nil
+}
@@ -0,0 +1,115 @@
+package vaulter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubSealStatusGetter struct {
+	resp      *vault.SealStatusResponse
+	sealError bool
+}
+
+func (s *StubSealStatusGetter) SealStatus() (*vault.SealStatusResponse, error) {
+	if s.sealError {
+		return nil, errors.New("seal status error")
+	}
+	return s.resp, nil
+}
+
+// autoUnsealStatus is a seal status response from a Vault server using an
+// awskms seal with a seal migration in progress.
+const autoUnsealStatus = `{
+	"type": "awskms",
+	"initialized": true,
+	"sealed": false,
+	"t": 3,
+	"n": 5,
+	"progress": 0,
+	"nonce": "",
+	"version": "1.15.2",
+	"migration": true,
+	"recovery_seal": true,
+	"recovery_seal_type": "shamir",
+	"storage_type": "raft"
+}`
+
+func TestRecoverySealStatus(t *testing.T) {
+	resp := &vault.SealStatusResponse{}
+	if err := json.Unmarshal([]byte(autoUnsealStatus), resp); err != nil {
+		t.Fatal(err)
+	}
+	status, err := RecoverySealStatus(&StubSealStatusGetter{resp: resp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Type != "awskms" {
+		t.Errorf("type was '%s' instead of 'awskms'", status.Type)
+	}
+	if !status.RecoverySeal {
+		t.Error("recovery_seal was false")
+	}
+	if status.RecoverySealType != "shamir" {
+		t.Errorf("recovery_seal_type was '%s' instead of 'shamir'", status.RecoverySealType)
+	}
+	if !status.Migration {
+		t.Error("migration was false")
+	}
+	if status.T != 3 || status.N != 5 {
+		t.Errorf("recovery key threshold was %d of %d instead of 3 of 5", status.T, status.N)
+	}
+
+	shamir := &vault.SealStatusResponse{Type: "shamir"}
+	if _, err = RecoverySealStatus(&StubSealStatusGetter{resp: shamir}); err == nil {
+		t.Error("err was nil for a server without a recovery seal")
+	}
+	if _, err = RecoverySealStatus(&StubSealStatusGetter{sealError: true}); err == nil {
+		t.Error("err was nil for a seal status error")
+	}
+	if _, err = RecoverySealStatus(&StubSealStatusGetter{}); err == nil {
+		t.Error("err was nil for a missing seal status")
+	}
+}
+
+func TestSealStatus(t *testing.T) {
+	var s Sealer = &StubSealStatusGetter{resp: &vault.SealStatusResponse{Sealed: true, T: 3, N: 5, Progress: 1}}
+	status, err := SealStatus(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if !status.Sealed || status.Progress != 1 {
+		t.Errorf("status was %+v", status)
+	}
+
+	if _, err = SealStatus(&StubSealStatusGetter{sealError: true}); err == nil {
+		t.Error("err was nil for a seal status error")
+	}
+}
+
+func TestIsSealed(t *testing.T) {
+	sealed, err := IsSealed(&StubSealStatusGetter{resp: &vault.SealStatusResponse{Sealed: true}})
+	if err != nil {
+		t.Error(err)
+	}
+	if !sealed {
+		t.Error("sealed was false for a sealed response")
+	}
+
+	sealed, err = IsSealed(&StubSealStatusGetter{resp: &vault.SealStatusResponse{Sealed: false}})
+	if err != nil {
+		t.Error(err)
+	}
+	if sealed {
+		t.Error("sealed was true for an unsealed response")
+	}
+
+	if _, err = IsSealed(&StubSealStatusGetter{sealError: true}); err == nil {
+		t.Error("err was nil for a seal status error")
+	}
+	if _, err = IsSealed(&StubSealStatusGetter{}); err == nil {
+		t.Error("err was nil for a missing seal status")
+	}
+}
@@ -0,0 +1,170 @@
+package vaulter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// TokenLookuper is an interface for objects that can look up information about
+// Vault tokens.
+type TokenLookuper interface {
+	LookupSelf() (*vault.Secret, error)
+	Lookup(token string) (*vault.Secret, error)
+}
+
+// TokenRevoker is an interface for objects that can revoke Vault tokens.
+type TokenRevoker interface {
+	RevokeToken(token string) error
+}
+
+// dataInt converts a numeric value from a secret's Data map into an int.
+func dataInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+// ParentTokenUsesRemaining returns the number of uses remaining on the token
+// configured for the client. Returns -1 if the token has unlimited uses. The
+// token is looked up with lookup-self, which spends one of its uses, so the
+// count is what's left after the lookup, and a token with a single use left is
+// used up by the call. To check a token without spending a use, look it up
+// with another token, the way VerifyTokenUses does.
+func ParentTokenUsesRemaining(t TokenLookuper) (int, error) {
+	secret, err := t.LookupSelf()
+	if err != nil {
+		return 0, err
+	}
+	return tokenUses(secret)
+}
+
+// VerifyTokenUses returns an error unless the token has exactly the expected
+// number of uses remaining, where 0 means unlimited uses. The token is looked
+// up with the client's own token, so the check doesn't spend one of its uses.
+func VerifyTokenUses(t TokenLookuper, token string, expected int) error {
+	secret, err := t.Lookup(token)
+	if err != nil {
+		return err
+	}
+	uses, err := tokenUses(secret)
+	if err != nil {
+		return err
+	}
+	if uses == -1 {
+		uses = 0
+	}
+	if uses != expected {
+		return fmt.Errorf("the token has %d uses remaining instead of %d", uses, expected)
+	}
+	return nil
+}
+
+// tokenUses returns the number of uses remaining on the token described by the
+// lookup secret. Returns -1 if the token has unlimited uses.
+func tokenUses(secret *vault.Secret) (int, error) {
+	if secret == nil || secret.Data == nil {
+		return 0, errors.New("no data was returned for the token lookup")
+	}
+	v, ok := secret.Data["num_uses"]
+	if !ok {
+		return 0, errors.New("num_uses is missing from the token lookup")
+	}
+	uses, err := dataInt(v)
+	if err != nil {
+		return 0, err
+	}
+	if uses == 0 {
+		return -1, nil
+	}
+	return uses, nil
+}
+
+// secretKeys returns the keys from the response to a LIST request. A nil secret
+// means that nothing was found, so an empty slice is returned.
+func secretKeys(secret *vault.Secret) ([]string, error) {
+	keys := []string{}
+	if secret == nil || secret.Data == nil {
+		return keys, nil
+	}
+	v, ok := secret.Data["keys"]
+	if !ok || v == nil {
+		return keys, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("keys has unexpected type %T", v)
+	}
+	for _, k := range list {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("key %v is not a string", k)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ListTokenAccessors returns the accessors for all of the tokens in Vault.
+func ListTokenAccessors(l LogicalLister) ([]string, error) {
+	return ListPath(l, "auth/token/accessors")
+}
+
+// ForEachAccessor calls fn with each of the token accessors in Vault, stopping
+// at and returning the first error returned by fn. Vault returns the accessors
+// in a single response, but this avoids building a second copy of the list and
+// lets callers stop early.
+func ForEachAccessor(l LogicalLister, fn func(accessor string) error) error {
+	secret, err := l.List(l.Client(), "auth/token/accessors")
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil
+	}
+	list, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, k := range list {
+		accessor, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("accessor %v is not a string", k)
+		}
+		if err = fn(accessor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsRootToken returns true if the token configured for the client has the root
+// policy attached to it.
+func IsRootToken(t TokenLookuper) (bool, error) {
+	secret, err := t.LookupSelf()
+	if err != nil {
+		return false, err
+	}
+	policies, err := tokenPolicies(secret)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range policies {
+		if p == "root" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,224 @@
+package vaulter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubTokenLookuper struct {
+	token       string
+	data        map[string]interface{}
+	lookupError bool
+}
+
+func (s *StubTokenLookuper) LookupSelf() (*vault.Secret, error) {
+	if s.lookupError {
+		return nil, errors.New("lookup error")
+	}
+	return &vault.Secret{Data: s.data}, nil
+}
+
+func (s *StubTokenLookuper) Lookup(token string) (*vault.Secret, error) {
+	s.token = token
+	return s.LookupSelf()
+}
+
+func TestParentTokenUsesRemaining(t *testing.T) {
+	tl := &StubTokenLookuper{
+		data: map[string]interface{}{
+			"num_uses": json.Number("5"),
+		},
+	}
+	uses, err := ParentTokenUsesRemaining(tl)
+	if err != nil {
+		t.Error(err)
+	}
+	if uses != 5 {
+		t.Errorf("uses was %d instead of 5", uses)
+	}
+
+	tl = &StubTokenLookuper{
+		data: map[string]interface{}{
+			"num_uses": json.Number("0"),
+		},
+	}
+	uses, err = ParentTokenUsesRemaining(tl)
+	if err != nil {
+		t.Error(err)
+	}
+	if uses != -1 {
+		t.Errorf("uses was %d instead of -1", uses)
+	}
+
+	tl = &StubTokenLookuper{data: map[string]interface{}{}}
+	if _, err = ParentTokenUsesRemaining(tl); err == nil {
+		t.Error("err was nil for a missing num_uses")
+	}
+
+	tl = &StubTokenLookuper{lookupError: true}
+	if _, err = ParentTokenUsesRemaining(tl); err == nil {
+		t.Error("err was nil for a lookup error")
+	}
+}
+
+type StubLister struct {
+	path      string
+	keys      []interface{}
+	listError bool
+}
+
+func (s *StubLister) Client() *vault.Client {
+	return &vault.Client{}
+}
+
+func (s *StubLister) List(client *vault.Client, path string) (*vault.Secret, error) {
+	s.path = path
+	if s.listError {
+		return nil, errors.New("list error")
+	}
+	if s.keys == nil {
+		return nil, nil
+	}
+	return &vault.Secret{
+		Data: map[string]interface{}{
+			"keys": s.keys,
+		},
+	}, nil
+}
+
+func TestListTokenAccessors(t *testing.T) {
+	l := &StubLister{keys: []interface{}{"accessor1", "accessor2", "accessor3"}}
+	accessors, err := ListTokenAccessors(l)
+	if err != nil {
+		t.Error(err)
+	}
+	if l.path != "auth/token/accessors" {
+		t.Errorf("path was '%s' instead of 'auth/token/accessors'", l.path)
+	}
+	if len(accessors) != 3 || accessors[0] != "accessor1" || accessors[2] != "accessor3" {
+		t.Errorf("accessors were %v", accessors)
+	}
+
+	l = &StubLister{}
+	accessors, err = ListTokenAccessors(l)
+	if err != nil {
+		t.Error(err)
+	}
+	if accessors == nil || len(accessors) != 0 {
+		t.Errorf("accessors were %v instead of an empty slice", accessors)
+	}
+
+	l = &StubLister{listError: true}
+	if _, err = ListTokenAccessors(l); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestForEachAccessor(t *testing.T) {
+	l := &StubLister{keys: []interface{}{"accessor1", "accessor2", "accessor3"}}
+	var seen []string
+	err := ForEachAccessor(l, func(accessor string) error {
+		seen = append(seen, accessor)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(seen) != 3 || seen[1] != "accessor2" {
+		t.Errorf("seen was %v", seen)
+	}
+
+	seen = nil
+	err = ForEachAccessor(l, func(accessor string) error {
+		seen = append(seen, accessor)
+		if accessor == "accessor2" {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "stop" {
+		t.Errorf("err was %v instead of 'stop'", err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("fn was called %d times instead of 2", len(seen))
+	}
+
+	l = &StubLister{}
+	err = ForEachAccessor(l, func(accessor string) error {
+		t.Errorf("fn was called with %s for an empty list", accessor)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	l = &StubLister{listError: true}
+	if err = ForEachAccessor(l, func(string) error { return nil }); err == nil {
+		t.Error("err was nil")
+	}
+}
+
+func TestIsRootToken(t *testing.T) {
+	tl := &StubTokenLookuper{
+		data: map[string]interface{}{
+			"policies": []interface{}{"root"},
+		},
+	}
+	isRoot, err := IsRootToken(tl)
+	if err != nil {
+		t.Error(err)
+	}
+	if !isRoot {
+		t.Error("isRoot was false for the root token")
+	}
+
+	tl.data["policies"] = []interface{}{"default", "provisioner"}
+	if isRoot, err = IsRootToken(tl); err != nil {
+		t.Error(err)
+	}
+	if isRoot {
+		t.Error("isRoot was true for a scoped token")
+	}
+
+	tl = &StubTokenLookuper{lookupError: true}
+	if _, err = IsRootToken(tl); err == nil {
+		t.Error("err was nil for a lookup error")
+	}
+}
+
+func TestVerifyTokenUses(t *testing.T) {
+	tl := &StubTokenLookuper{
+		data: map[string]interface{}{
+			"num_uses": json.Number("1"),
+		},
+	}
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 1); err != nil {
+		t.Error(err)
+	}
+	if tl.token != "cubbyhole-token" {
+		t.Errorf("token was '%s' instead of 'cubbyhole-token'", tl.token)
+	}
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 2); err == nil {
+		t.Error("err was nil for a mismatched use count")
+	}
+
+	tl.data["num_uses"] = json.Number("0")
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 0); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 1); err == nil {
+		t.Error("err was nil for a token with unlimited uses")
+	}
+
+	tl = &StubTokenLookuper{data: map[string]interface{}{}}
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 1); err == nil {
+		t.Error("err was nil for a missing num_uses")
+	}
+	tl = &StubTokenLookuper{lookupError: true}
+	if err := VerifyTokenUses(tl, "cubbyhole-token", 1); err == nil {
+		t.Error("err was nil for a lookup error")
+	}
+}
@@ -0,0 +1,38 @@
+package vaulter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TransitGenerateDataKey generates a new data key that's encrypted with the
+// named key in the transit backend mounted at the given path. keyType must be
+// either "plaintext", in which case the base64-encoded plaintext of the data
+// key is returned along with its ciphertext, or "wrapped", in which case only
+// the ciphertext is returned.
+func TransitGenerateDataKey(m MountReaderWriter, mount, keyName, keyType string) (plaintext, ciphertext string, err error) {
+	if keyType != "plaintext" && keyType != "wrapped" {
+		return "", "", fmt.Errorf("invalid data key type %s", keyType)
+	}
+	client := m.Client()
+	path := fmt.Sprintf("%s/datakey/%s/%s", mount, keyType, keyName)
+	secret, err := m.Write(client, path, map[string]interface{}{})
+	if err != nil {
+		return "", "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", "", errors.New("no data was returned for the data key")
+	}
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok || ciphertext == "" {
+		return "", "", errors.New("the data key is missing its ciphertext")
+	}
+	if keyType == "wrapped" {
+		return "", ciphertext, nil
+	}
+	plaintext, ok = secret.Data["plaintext"].(string)
+	if !ok || plaintext == "" {
+		return "", "", errors.New("the data key is missing its plaintext")
+	}
+	return plaintext, ciphertext, nil
+}
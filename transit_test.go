@@ -0,0 +1,70 @@
+package vaulter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type StubDataKeyWriter struct {
+	StubMountReaderWriter
+}
+
+func (s *StubDataKeyWriter) Write(client *vault.Client, path string, data map[string]interface{}) (*vault.Secret, error) {
+	s.path = path
+	if s.writeError {
+		return nil, errors.New("write error")
+	}
+	retval := &vault.Secret{
+		Data: map[string]interface{}{
+			"ciphertext": "vault:v1:abcdef",
+		},
+	}
+	if strings.Contains(path, "/plaintext/") {
+		retval.Data["plaintext"] = "cGxhaW50ZXh0"
+	}
+	return retval, nil
+}
+
+func TestTransitGenerateDataKey(t *testing.T) {
+	w := &StubDataKeyWriter{}
+	plaintext, ciphertext, err := TransitGenerateDataKey(w, "transit", "blobs", "plaintext")
+	if err != nil {
+		t.Error(err)
+	}
+	if w.path != "transit/datakey/plaintext/blobs" {
+		t.Errorf("path was '%s' instead of 'transit/datakey/plaintext/blobs'", w.path)
+	}
+	if plaintext != "cGxhaW50ZXh0" {
+		t.Errorf("plaintext was '%s' instead of 'cGxhaW50ZXh0'", plaintext)
+	}
+	if ciphertext != "vault:v1:abcdef" {
+		t.Errorf("ciphertext was '%s' instead of 'vault:v1:abcdef'", ciphertext)
+	}
+
+	w = &StubDataKeyWriter{}
+	plaintext, ciphertext, err = TransitGenerateDataKey(w, "transit", "blobs", "wrapped")
+	if err != nil {
+		t.Error(err)
+	}
+	if w.path != "transit/datakey/wrapped/blobs" {
+		t.Errorf("path was '%s' instead of 'transit/datakey/wrapped/blobs'", w.path)
+	}
+	if plaintext != "" {
+		t.Errorf("plaintext was '%s' instead of ''", plaintext)
+	}
+	if ciphertext != "vault:v1:abcdef" {
+		t.Errorf("ciphertext was '%s' instead of 'vault:v1:abcdef'", ciphertext)
+	}
+
+	if _, _, err = TransitGenerateDataKey(w, "transit", "blobs", "bogus"); err == nil {
+		t.Error("err was nil for an invalid key type")
+	}
+
+	w = &StubDataKeyWriter{StubMountReaderWriter{writeError: true}}
+	if _, _, err = TransitGenerateDataKey(w, "transit", "blobs", "plaintext"); err == nil {
+		t.Error("err was nil for a write error")
+	}
+}
@@ -1,18 +1,27 @@
 package vaulter
 
 import (
+	"errors"
 	"fmt"
+	"log"
 
+	"github.com/hashicorp/go-retryablehttp"
 	vault "github.com/hashicorp/vault/api"
 )
 
-// InitAPI initializes the provided *VaultAPI. This should be called first.
+// InitAPI initializes the provided *VaultAPI. This should be called first. If
+// the token is empty and cfg.RoleID and cfg.SecretID are set, the token is
+// obtained with an AppRole login. Otherwise, if the token is empty and
+// cfg.KubernetesRole is set, the token is obtained with a Kubernetes login. If
+// cfg.CheckRootToken or cfg.RejectRootToken is set, the token is looked up to
+// make sure it isn't the root token.
 func InitAPI(api *VaultAPI, cfg *VaultAPIConfig, token string) error {
 	var err error
 	tlsconfig := &vault.TLSConfig{
 		CACert:     cfg.CACert,
 		ClientCert: cfg.ClientCert,
 		ClientKey:  cfg.ClientKey,
+		Insecure:   cfg.Insecure,
 	}
 	apicfg := api.DefaultConfig()
 	apicfg.Address = fmt.Sprintf(
@@ -21,6 +30,19 @@ func InitAPI(api *VaultAPI, cfg *VaultAPIConfig, token string) error {
 		cfg.Host,
 		cfg.Port,
 	)
+	if cfg.MaxRetries != 0 {
+		apicfg.MaxRetries = cfg.MaxRetries
+	}
+	if cfg.MinRetryWait != 0 {
+		apicfg.MinRetryWait = cfg.MinRetryWait
+	}
+	if cfg.MaxRetryWait != 0 {
+		apicfg.MaxRetryWait = cfg.MaxRetryWait
+	}
+	// Rate-limited (429) responses are retried after the wait Vault asks for
+	// in their Retry-After header, even if it's longer than MaxRetryWait.
+	apicfg.CheckRetry = vault.DefaultRetryPolicy
+	apicfg.Backoff = retryablehttp.RateLimitLinearJitterBackoff
 	if err = api.ConfigureTLS(apicfg, tlsconfig); err != nil {
 		return err
 	}
@@ -30,6 +52,39 @@ func InitAPI(api *VaultAPI, cfg *VaultAPIConfig, token string) error {
 	}
 	api.SetToken(client, token)
 	api.SetClient(client)
+	if cfg.Namespace != "" {
+		api.SetNamespace(cfg.Namespace)
+	}
+	if token == "" && cfg.RoleID != "" && cfg.SecretID != "" {
+		if token, err = LoginAppRole(api, cfg.RoleID, cfg.SecretID); err != nil {
+			return err
+		}
+		api.SetToken(client, token)
+	} else if token == "" && cfg.KubernetesRole != "" {
+		var jwt string
+		if jwt, err = readKubernetesJWT(cfg.KubernetesJWTPath); err != nil {
+			return err
+		}
+		if token, err = LoginKubernetes(api, cfg.KubernetesRole, jwt); err != nil {
+			return err
+		}
+		api.SetToken(client, token)
+	}
 	api.SetConfig(apicfg)
+	api.SetRoleDefaults(cfg.RoleDefaults)
+	api.SetDisplayNamePrefix(cfg.DisplayNamePrefix)
+	api.SetRemountTimeout(cfg.RemountTimeout)
+	if cfg.CheckRootToken || cfg.RejectRootToken {
+		var isRoot bool
+		if isRoot, err = IsRootToken(api); err != nil {
+			return err
+		}
+		if isRoot {
+			if cfg.RejectRootToken {
+				return errors.New("the vault token is the root token, use a scoped token instead")
+			}
+			log.Printf("warning: the vault token is the root token, consider using a scoped token instead")
+		}
+	}
 	return nil
 }
@@ -0,0 +1,440 @@
+package vaulter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// newTestClient returns a *vault.Client that talks to an httptest.Server
+// backed by the provided handler. The server is shut down when the test ends.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *vault.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cfg := vault.DefaultConfig()
+	cfg.Address = server.URL
+	cfg.MaxRetries = 0
+	client, err := vault.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("test-token")
+	return client
+}
+
+// StubClientGetter is a ClientGetter that returns a preconfigured client.
+type StubClientGetter struct {
+	client *vault.Client
+}
+
+func (s *StubClientGetter) Client() *vault.Client {
+	return s.client
+}
+
+func TestInitAPI(t *testing.T) {
+	api := &VaultAPI{}
+	cfg := &VaultAPIConfig{
+		Host:         "vault.example.com",
+		Port:         "8200",
+		Scheme:       "https",
+		MaxRetries:   5,
+		MinRetryWait: 250 * time.Millisecond,
+		MaxRetryWait: 10 * time.Second,
+	}
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	apicfg := api.GetConfig()
+	expected := "https://vault.example.com:8200"
+	if apicfg.Address != expected {
+		t.Errorf("address was '%s' instead of '%s'", apicfg.Address, expected)
+	}
+	if apicfg.MaxRetries != 5 {
+		t.Errorf("MaxRetries was %d instead of 5", apicfg.MaxRetries)
+	}
+	if apicfg.MinRetryWait != 250*time.Millisecond {
+		t.Errorf("MinRetryWait was %s instead of 250ms", apicfg.MinRetryWait)
+	}
+	if apicfg.MaxRetryWait != 10*time.Second {
+		t.Errorf("MaxRetryWait was %s instead of 10s", apicfg.MaxRetryWait)
+	}
+	if api.RoleDefaults() != nil {
+		t.Error("role defaults were set when none were configured")
+	}
+	if api.Client().Token() != "token" {
+		t.Errorf("token was '%s' instead of 'token'", api.Client().Token())
+	}
+
+	api = &VaultAPI{}
+	cfg = &VaultAPIConfig{
+		Host:   "vault.example.com",
+		Port:   "8200",
+		Scheme: "https",
+	}
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	defcfg := vault.DefaultConfig()
+	apicfg = api.GetConfig()
+	if apicfg.MaxRetries != defcfg.MaxRetries {
+		t.Errorf("MaxRetries was %d instead of the default %d", apicfg.MaxRetries, defcfg.MaxRetries)
+	}
+	if apicfg.MinRetryWait != defcfg.MinRetryWait {
+		t.Errorf("MinRetryWait was %s instead of the default %s", apicfg.MinRetryWait, defcfg.MinRetryWait)
+	}
+	if apicfg.MaxRetryWait != defcfg.MaxRetryWait {
+		t.Errorf("MaxRetryWait was %s instead of the default %s", apicfg.MaxRetryWait, defcfg.MaxRetryWait)
+	}
+}
+
+func TestInitAPIInsecure(t *testing.T) {
+	for _, insecure := range []bool{false, true} {
+		api := &VaultAPI{}
+		cfg := &VaultAPIConfig{
+			Host:     "vault.example.com",
+			Port:     "8200",
+			Scheme:   "https",
+			Insecure: insecure,
+		}
+		if err := InitAPI(api, cfg, "token"); err != nil {
+			t.Fatal(err)
+		}
+		transport, ok := api.GetConfig().HttpClient.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("the transport was a %T instead of a *http.Transport", api.GetConfig().HttpClient.Transport)
+		}
+		if transport.TLSClientConfig.InsecureSkipVerify != insecure {
+			t.Errorf("InsecureSkipVerify was %t instead of %t", transport.TLSClientConfig.InsecureSkipVerify, insecure)
+		}
+	}
+}
+
+func TestInitAPIInsecureWriteMount(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		paths = append(paths, req.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := &VaultAPI{}
+	cfg := &VaultAPIConfig{
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		Scheme:   "https",
+		Insecure: true,
+	}
+	if err = InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server's cert is self-signed, so the write only succeeds if the
+	// write client skips verification the same way the configured client does.
+	if err = WriteMount(api, "cubbyhole/token", "child-token", map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "/v1/cubbyhole/token" {
+		t.Errorf("paths were %v instead of [/v1/cubbyhole/token]", paths)
+	}
+}
+
+func TestInitAPIRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		n := len(times)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"errors":["request path \"secret/foo\": rate limit quota exceeded"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"foo":"bar"}}`)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := &VaultAPI{}
+	cfg := &VaultAPIConfig{
+		Host:         u.Hostname(),
+		Port:         u.Port(),
+		Scheme:       "http",
+		MaxRetries:   2,
+		MinRetryWait: 10 * time.Millisecond,
+		MaxRetryWait: 20 * time.Millisecond,
+	}
+	if err = InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := api.Read(api.Client(), "secret/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["foo"] != "bar" {
+		t.Errorf("foo was %v instead of bar", secret.Data["foo"])
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 2 {
+		t.Fatalf("there were %d requests instead of 2", len(times))
+	}
+	if wait := times[1].Sub(times[0]); wait < time.Second {
+		t.Errorf("the retry came after %s instead of the 1s asked for in Retry-After", wait)
+	}
+}
+
+func TestInitAPIRoleDefaults(t *testing.T) {
+	api := &VaultAPI{}
+	defaults := &RoleDefaults{
+		Role:           "htcondor",
+		AllowedDomains: "example.com",
+	}
+	cfg := &VaultAPIConfig{
+		Host:         "vault.example.com",
+		Port:         "8200",
+		Scheme:       "https",
+		RoleDefaults: defaults,
+	}
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if api.RoleDefaults() != defaults {
+		t.Error("the role defaults were not applied to the VaultAPI")
+	}
+}
+
+func TestInitAPIDisplayNamePrefix(t *testing.T) {
+	api := &VaultAPI{}
+	cfg := &VaultAPIConfig{
+		Host:              "vault.example.com",
+		Port:              "8200",
+		Scheme:            "https",
+		DisplayNamePrefix: "jobworker",
+	}
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if api.DisplayNamePrefix() != "jobworker" {
+		t.Errorf("display name prefix was '%s' instead of 'jobworker'", api.DisplayNamePrefix())
+	}
+}
+
+func TestInitAPINamespace(t *testing.T) {
+	api := &VaultAPI{}
+	cfg := &VaultAPIConfig{
+		Host:      "vault.example.com",
+		Port:      "8200",
+		Scheme:    "https",
+		Namespace: "cyverse/de",
+	}
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if api.Client().Namespace() != "cyverse/de" {
+		t.Errorf("namespace was '%s' instead of 'cyverse/de'", api.Client().Namespace())
+	}
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Namespace() != "cyverse/de" {
+		t.Errorf("namespace of a new client was '%s' instead of 'cyverse/de'", client.Namespace())
+	}
+
+	api = &VaultAPI{}
+	cfg.Namespace = ""
+	if err := InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if api.Client().Namespace() != "" {
+		t.Errorf("namespace was '%s' when none was configured", api.Client().Namespace())
+	}
+}
+
+// newLookupSelfConfig returns a VaultAPIConfig pointing at a test server that
+// answers token lookups with the provided policies.
+func newLookupSelfConfig(t *testing.T, policies string) *VaultAPIConfig {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/auth/token/lookup-self" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":{"policies":%s}}`, policies)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &VaultAPIConfig{
+		Host:   u.Hostname(),
+		Port:   u.Port(),
+		Scheme: u.Scheme,
+	}
+}
+
+func TestInitAPIAppRole(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/auth/approle/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(body, "bad-secret-id") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":["invalid secret id"]}`)
+			return
+		}
+		fmt.Fprint(w, `{"auth":{"client_token":"approle-token"}}`)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &VaultAPIConfig{
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		Scheme:   u.Scheme,
+		RoleID:   "role-id",
+		SecretID: "secret-id",
+	}
+
+	api := &VaultAPI{}
+	if err = InitAPI(api, cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	if api.Client().Token() != "approle-token" {
+		t.Errorf("token was '%s' instead of 'approle-token'", api.Client().Token())
+	}
+	if !strings.Contains(body, `"role_id":"role-id"`) || !strings.Contains(body, `"secret_id":"secret-id"`) {
+		t.Errorf("the login request did not include the credentials: %s", body)
+	}
+
+	api = &VaultAPI{}
+	if err = InitAPI(api, cfg, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if api.Client().Token() != "token" {
+		t.Errorf("token was '%s' instead of 'token'", api.Client().Token())
+	}
+
+	cfg.SecretID = "bad-secret-id"
+	if err = InitAPI(&VaultAPI{}, cfg, ""); err == nil {
+		t.Error("err was nil when the login failed")
+	}
+}
+
+func TestInitAPIKubernetes(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/auth/kubernetes/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"auth":{"client_token":"k8s-token"}}`)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err = os.WriteFile(jwtPath, []byte("header.payload.signature"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &VaultAPIConfig{
+		Host:              u.Hostname(),
+		Port:              u.Port(),
+		Scheme:            u.Scheme,
+		KubernetesRole:    "jobservices",
+		KubernetesJWTPath: jwtPath,
+	}
+
+	api := &VaultAPI{}
+	if err = InitAPI(api, cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	if api.Client().Token() != "k8s-token" {
+		t.Errorf("token was '%s' instead of 'k8s-token'", api.Client().Token())
+	}
+	if !strings.Contains(body, `"role":"jobservices"`) || !strings.Contains(body, `"jwt":"header.payload.signature"`) {
+		t.Errorf("the login request did not include the role and jwt: %s", body)
+	}
+
+	cfg.KubernetesJWTPath = filepath.Join(t.TempDir(), "missing")
+	if err = InitAPI(&VaultAPI{}, cfg, ""); err == nil {
+		t.Error("err was nil when the service account token was missing")
+	}
+}
+
+func TestInitAPIRootToken(t *testing.T) {
+	var buf bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(orig) })
+
+	cfg := newLookupSelfConfig(t, `["root"]`)
+	cfg.CheckRootToken = true
+	if err := InitAPI(&VaultAPI{}, cfg, "token"); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(buf.String(), "root token") {
+		t.Errorf("no warning was logged for the root token: %q", buf.String())
+	}
+
+	cfg.RejectRootToken = true
+	if err := InitAPI(&VaultAPI{}, cfg, "token"); err == nil {
+		t.Error("err was nil for the root token in strict mode")
+	}
+
+	buf.Reset()
+	cfg = newLookupSelfConfig(t, `["default","provisioner"]`)
+	cfg.RejectRootToken = true
+	if err := InitAPI(&VaultAPI{}, cfg, "token"); err != nil {
+		t.Error(err)
+	}
+	cfg.CheckRootToken = true
+	cfg.RejectRootToken = false
+	if err := InitAPI(&VaultAPI{}, cfg, "token"); err != nil {
+		t.Error(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("a warning was logged for a scoped token: %q", buf.String())
+	}
+}